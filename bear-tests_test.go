@@ -5,10 +5,15 @@
 package bear
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type tester func(*testing.T)
@@ -989,3 +994,1988 @@ func TestAlwaysRejection(t *testing.T) {
 		t.Errorf("Always requires non-nil handler")
 	}
 }
+
+func TestAfter(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		pattern      = "/foo"
+		method       = "GET"
+		path         = "/foo"
+		req     *http.Request
+		res     *httptest.ResponseRecorder
+		want    = "handler,one,two"
+		visited []string
+	)
+	one := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "one")
+		ctx.Next() // must be a no-op
+	}
+	two := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "two")
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "handler")
+	}
+	mux.After(one, two)
+	mux.On(method, pattern, handler)
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if got := strings.Join(visited, ","); got != want {
+		t.Errorf("%s %s got %s want %s", method, path, got, want)
+	}
+}
+
+func TestAfterRejection(t *testing.T) {
+	var (
+		mux *Mux = New()
+		one HandlerFunc
+		two func(http.ResponseWriter, *http.Request)
+	)
+	if err := mux.After(one); err == nil {
+		t.Errorf("After requires non-nil handler")
+	}
+	if err := mux.After(two); err == nil {
+		t.Errorf("After requires a bear.HandlerFunc")
+	}
+}
+
+func TestDefer(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		pattern      = "/foo"
+		method       = "GET"
+		path         = "/foo"
+		req     *http.Request
+		res     *httptest.ResponseRecorder
+		want    = "handler,after,two,one"
+		visited []string
+	)
+	always := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		ctx.Defer(func() { visited = append(visited, "one") }).Next()
+	}
+	after := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "after")
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		ctx.Defer(func() { visited = append(visited, "two") })
+		visited = append(visited, "handler")
+	}
+	mux.Always(always)
+	mux.After(after)
+	mux.On(method, pattern, handler)
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if got := strings.Join(visited, ","); got != want {
+		t.Errorf("%s %s got %s want %s", method, path, got, want)
+	}
+}
+
+func TestDeferPanic(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		pattern      = "/foo"
+		method       = "GET"
+		path         = "/foo"
+		req     *http.Request
+		res     *httptest.ResponseRecorder
+		want    = "after,two,one"
+		visited []string
+	)
+	after := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "after")
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		ctx.Defer(func() { visited = append(visited, "one") })
+		ctx.Defer(func() { visited = append(visited, "two") })
+		panic("handler panic")
+	}
+	mux.After(after)
+	mux.On(method, pattern, handler)
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s %s panic should propagate", method, path)
+			}
+		}()
+		mux.ServeHTTP(res, req)
+	}()
+	if got := strings.Join(visited, ","); got != want {
+		t.Errorf("%s %s got %s want %s", method, path, got, want)
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	var (
+		mux      *Mux = New()
+		pattern       = "/foo/{bar}/baz"
+		method        = "GET"
+		path          = "/foo/BAR/qux"
+		req      *http.Request
+		res      *httptest.ResponseRecorder
+		keyOne   = "one"
+		stateOne = 1
+		params   = map[string]string{"bar": "BAR"}
+		want     = http.StatusTeapot
+	)
+	always := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		ctx.Set(keyOne, stateOne).Next()
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, _ *Context) {
+		t.Errorf("handler should not be fired because path != pattern")
+	}
+	notFound := func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+		if !reflect.DeepEqual(ctx.Get(keyOne), stateOne) {
+			t.Errorf("Always middleware did not execute before NotFound")
+		}
+		if !reflect.DeepEqual(params, ctx.Params) {
+			t.Errorf("%s %s got %v want %v", method, path, ctx.Params, params)
+		}
+		res.WriteHeader(http.StatusTeapot)
+	}
+	mux.Always(always)
+	mux.On(method, pattern, handler)
+	if err := mux.NotFound(notFound); err != nil {
+		t.Error(err)
+	}
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != want {
+		t.Errorf("%s %s got %d want %d", method, path, res.Code, want)
+	}
+}
+
+func TestNotFoundHandlerBadVerb(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "BLUB"
+		path        = "/"
+		req    *http.Request
+		res    *httptest.ResponseRecorder
+		want   = http.StatusTeapot
+	)
+	mux.On("*", path, func(http.ResponseWriter, *http.Request) {})
+	mux.NotFound(func(res http.ResponseWriter, _ *http.Request) {
+		res.WriteHeader(http.StatusTeapot)
+	})
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != want {
+		t.Errorf("%s %s got %d want %d", method, path, res.Code, want)
+	}
+}
+
+func TestNotFoundHandlerRejection(t *testing.T) {
+	var (
+		mux *Mux = New()
+		one HandlerFunc
+		two = func(http.ResponseWriter, *http.Request) {}
+	)
+	if err := mux.NotFound(one); err == nil {
+		t.Errorf("NotFound requires non-nil handler")
+	}
+	if err := mux.NotFound(two); err != nil {
+		t.Error(err)
+	}
+	if err := mux.NotFound(two); err == nil {
+		t.Errorf("NotFound addition must fail because it is a duplicate")
+	}
+}
+
+type testHandler struct{ visited *int }
+
+func (handler testHandler) ServeHTTP(
+	_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+	*handler.visited++
+	ctx.Next()
+}
+
+func TestHandlerInterfaces(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		method       = "GET"
+		path         = "/foo/bar"
+		pattern      = "/foo/*"
+		req     *http.Request
+		res     *httptest.ResponseRecorder
+		visited int
+		want    = "bar"
+	)
+	handler := http.StripPrefix("/foo/", http.HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request) {
+			res.Write([]byte(req.URL.Path))
+		}))
+	if err := mux.Always(testHandler{&visited}); err != nil {
+		t.Error(err)
+	}
+	if err := mux.On(method, pattern, testHandler{&visited}, handler); err != nil {
+		t.Error(err)
+	}
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if body := res.Body.String(); body != want {
+		t.Errorf("%s %s (%s) got %s want %s", method, path, pattern, body, want)
+	}
+	if visited != 2 {
+		t.Errorf("%s %s (%s) expected 2 bear.Handler visits, got %d",
+			method, path, pattern, visited)
+	}
+}
+
+func TestHandlerInterfacesUnreachable(t *testing.T) {
+	mux := New()
+	one := http.NotFoundHandler()
+	two := func(http.ResponseWriter, *http.Request, *Context) {}
+	if err := mux.On("*", "*", one, two); err == nil {
+		t.Errorf("http.Handler must not be followed by other handlers")
+	}
+	if err := mux.Always(one); err == nil {
+		t.Errorf("Always requires chainable middleware")
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		method       = "GET"
+		path         = "/foo/BAR"
+		pattern      = "/foo/{bar}"
+		req     *http.Request
+		res     *httptest.ResponseRecorder
+		header  = "X-Adapted"
+		want    = "BAR"
+	)
+	middleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Set(header, "yes")
+			next.ServeHTTP(res, req)
+		})
+	}
+	handler := func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+		if res.Header().Get(header) != "yes" {
+			t.Errorf("%s %s (%s) middleware did not run", method, path, pattern)
+		}
+		res.Write([]byte(ctx.Params["bar"]))
+	}
+	if err := mux.Always(middleware); err != nil {
+		t.Error(err)
+	}
+	if err := mux.On(method, pattern, Adapt(middleware), handler); err != nil {
+		t.Error(err)
+	}
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if body := res.Body.String(); body != want {
+		t.Errorf("%s %s (%s) got %s want %s", method, path, pattern, body, want)
+	}
+}
+
+func TestAdaptRejection(t *testing.T) {
+	var (
+		mux        *Mux = New()
+		middleware func(http.Handler) http.Handler
+	)
+	if err := mux.Always(middleware); err == nil {
+		t.Errorf("Always requires non-nil middleware")
+	}
+	if err := mux.On("GET", "/", middleware); err == nil {
+		t.Errorf("nil middleware was accepted")
+	}
+}
+
+func TestUse(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		visited []string
+	)
+	record := func(label string) HandlerFunc {
+		return func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+			visited = append(visited, label)
+			ctx.Next()
+		}
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "handler")
+	}
+	mux.Always(record("always"))
+	if err := mux.Use("/api/*", record("api")); err != nil {
+		t.Error(err)
+	}
+	if err := mux.Use("/api/{version}", record("version")); err != nil {
+		t.Error(err)
+	}
+	if err := mux.Use("/admin", record("admin")); err != nil {
+		t.Error(err)
+	}
+	mux.On("GET", "/api", handler)
+	mux.On("GET", "/api/{version}/users", handler)
+	mux.On("POST", "/api/{version}/users", handler)
+	mux.On("GET", "/files/*", handler)
+	mux.NotFound(handler)
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/api", "always,api,handler"},
+		{"GET", "/api/v1/users", "always,api,version,handler"},
+		{"POST", "/api/v1/users", "always,api,version,handler"},
+		{"GET", "/files/admin/foo", "always,handler"},
+		{"GET", "/admin/foo", "always,admin,handler"},
+		{"GET", "/foo", "always,handler"},
+	}
+	for _, test := range tests {
+		visited = nil
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		if got := strings.Join(visited, ","); got != test.want {
+			t.Errorf("%s %s got %s want %s", test.method, test.path, got, test.want)
+		}
+	}
+}
+
+func TestUseRejection(t *testing.T) {
+	var (
+		mux *Mux = New()
+		one HandlerFunc
+		two = func(http.ResponseWriter, *http.Request, *Context) {}
+	)
+	if err := mux.Use("/foo", one); err == nil {
+		t.Errorf("Use requires non-nil handler")
+	}
+	if err := mux.Use("/foo/*/bar", two); err == nil {
+		t.Errorf("Use requires the wildcard token to be last")
+	}
+}
+
+func TestHost(t *testing.T) {
+	var mux *Mux = New()
+	respond := func(label string) HandlerFunc {
+		return func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+			res.Write([]byte(label + ":" + ctx.Params["tenant"] + ctx.Params["id"]))
+		}
+	}
+	mux.On("GET", "/foo/{id}", respond("default"))
+	mux.Host("{tenant}.example.com").On("GET", "/foo/{id}", respond("tenant"))
+	mux.Host("API.example.com").On("GET", "/foo/{id}", respond("api"))
+	if mux.Host("{tenant}.example.com:8080") != mux.Host("{tenant}.example.com") {
+		t.Errorf("Host must return the same sub-router for the same pattern")
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"acme.example.com", "tenant:acme1"},
+		{"Acme.Example.COM:8080", "tenant:acme1"},
+		{"api.example.com", "api:1"},
+		{"api.example.com.", "api:1"},
+		{"example.com", "default:1"},
+		{"localhost:8080", "default:1"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/foo/1", nil)
+		req.Host = test.host
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if body := res.Body.String(); body != test.want {
+			t.Errorf("GET %s/foo/1 got %s want %s", test.host, body, test.want)
+		}
+	}
+}
+
+func TestHostInheritance(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		host         = "acme.example.com"
+		visited []string
+	)
+	record := func(label string) HandlerFunc {
+		return func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+			visited = append(visited, label)
+			ctx.Next()
+		}
+	}
+	mux.Always(record("always"))
+	mux.After(record("after"))
+	mux.Use("/", record("use"))
+	mux.CleanPath(CleanRedirect)
+	mux.NotFound(func(ctx *Context) {
+		ctx.Text(http.StatusNotFound, "missing "+ctx.Params["tenant"])
+	})
+	tenant := mux.Host("{tenant}.example.com")
+	tenant.Use("/", record("tenant"))
+	tenant.On("GET", "/foo", func(ctx *Context) {
+		visited = append(visited, "handler")
+		ctx.Text(http.StatusOK, ctx.Params["tenant"])
+	})
+	tests := []struct {
+		path    string
+		status  int
+		body    string
+		visited string
+	}{
+		{"/foo", http.StatusOK, "acme", "always,use,tenant,handler,after"},
+		{"//foo", http.StatusMovedPermanently, "", "after"},
+		{"/bar", http.StatusNotFound, "missing acme", "always,use,tenant,after"},
+	}
+	for _, test := range tests {
+		visited = nil
+		req, _ := http.NewRequest("GET", test.path, nil)
+		req.Host = host
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s%s got %d want %d",
+				host, test.path, res.Code, test.status)
+		}
+		if body := res.Body.String(); test.body != empty && body != test.body {
+			t.Errorf("GET %s%s got %q want %q", host, test.path, body, test.body)
+		}
+		if got := strings.Join(visited, ","); got != test.visited {
+			t.Errorf("GET %s%s got %s want %s",
+				host, test.path, got, test.visited)
+		}
+	}
+}
+
+func TestConditions(t *testing.T) {
+	var mux *Mux = New()
+	respond := func(label string) HandlerFunc {
+		return func(res http.ResponseWriter, _ *http.Request, _ *Context) {
+			res.Write([]byte(label))
+		}
+	}
+	conditions := []struct {
+		condition Condition
+		label     string
+	}{
+		{Header("X-Version", "2"), "header"},
+		{HeaderRegexp("X-Token", `^[a-f0-9]+$`), "regexp"},
+		{Query("debug", ""), "query"},
+		{ContentType("application/json"), "json"},
+		{ContentType("text/*"), "text"},
+	}
+	for _, c := range conditions {
+		if err := mux.On("POST", "/foo", c.condition, respond(c.label)); err != nil {
+			t.Error(err)
+		}
+	}
+	mux.On("GET", "/bar", Accept("application/json"), respond("json"))
+	mux.On("GET", "/bar", Accept("text/html"), respond("html"))
+	tests := []struct {
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+		want    string
+	}{
+		{"POST", "/foo", map[string]string{"X-Version": "2"}, 200, "header"},
+		{"POST", "/foo", map[string]string{"X-Token": "abc123"}, 200, "regexp"},
+		{"POST", "/foo?debug", nil, 200, "query"},
+		{"POST", "/foo", map[string]string{
+			"Content-Type": "application/json; charset=utf-8"}, 200, "json"},
+		{"POST", "/foo", map[string]string{"Content-Type": "text/csv"}, 200, "text"},
+		{"POST", "/foo", map[string]string{"Content-Type": "image/png"}, 415, ""},
+		{"GET", "/bar", nil, 200, "json"},
+		{"GET", "/bar", map[string]string{"Accept": "text/*"}, 200, "html"},
+		{"GET", "/bar", map[string]string{
+			"Accept": "application/json;q=0, text/html"}, 200, "html"},
+		{"GET", "/bar", map[string]string{"Accept": "image/png"}, 406, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("%s %s %v got %d want %d",
+				test.method, test.path, test.headers, res.Code, test.status)
+		} else if body := res.Body.String(); test.status == 200 && body != test.want {
+			t.Errorf("%s %s %v got %s want %s",
+				test.method, test.path, test.headers, body, test.want)
+		}
+	}
+}
+
+func TestConditionsFallback(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		method       = "GET"
+		path         = "/foo"
+		pattern      = "/foo"
+		req     *http.Request
+		res     *httptest.ResponseRecorder
+		want    = "fallback"
+	)
+	conditional := func(res http.ResponseWriter, _ *http.Request) {
+		res.Write([]byte("conditional"))
+	}
+	fallback := func(res http.ResponseWriter, _ *http.Request) {
+		res.Write([]byte("fallback"))
+	}
+	mux.On(method, pattern, Header("X-Foo", ""), conditional)
+	if err := mux.On(method, pattern, fallback); err != nil {
+		t.Error(err)
+	}
+	if err := mux.On(method, pattern, fallback); err == nil {
+		t.Errorf("%s %s addition must fail because it is a duplicate",
+			method, pattern)
+	}
+	req, _ = http.NewRequest(method, path, nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if body := res.Body.String(); body != want {
+		t.Errorf("%s %s (%s) got %s want %s", method, path, pattern, body, want)
+	}
+}
+
+func TestConditionsRejection(t *testing.T) {
+	mux := New()
+	handler := func(http.ResponseWriter, *http.Request) {}
+	if err := mux.On("GET", "/", HeaderRegexp("X-Foo", "("), handler); err == nil {
+		t.Errorf("invalid header regexp was accepted")
+	}
+	if err := mux.On("GET", "/", Condition{}, handler); err == nil {
+		t.Errorf("empty condition was accepted")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		sunset      = time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	)
+	respond := func(label string) HandlerFunc {
+		return func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+			res.Write([]byte(label + ctx.Params["id"]))
+		}
+	}
+	mux.VersionBy(Versioning{Header: "API-Version", Prefix: true, Vendor: "acme"})
+	mux.Version(1).On("GET", "/users/{id}", respond("v1:"))
+	mux.Version(1).On("GET", "/teams", respond("v1:teams"))
+	mux.Version(3).On("GET", "/users/{id}", respond("v3:"))
+	mux.Version(2).On("GET", "/users/{id}", respond("v2:"))
+	mux.On("GET", "/status", respond("status"))
+	if err := mux.Deprecate(1, time.Time{}, sunset); err != nil {
+		t.Error(err)
+	}
+	if err := mux.Deprecate(4, time.Time{}, sunset); err == nil {
+		t.Errorf("version 4 must not be deprecated because it does not exist")
+	}
+	tests := []struct {
+		path       string
+		headers    map[string]string
+		want       string
+		deprecated bool
+	}{
+		{"/users/1", nil, "v3:1", false},
+		{"/v2/users/1", nil, "v2:1", false},
+		{"/v1/users/1", map[string]string{"API-Version": "3"}, "v1:1", true},
+		{"/users/1", map[string]string{"API-Version": "v2"}, "v2:1", false},
+		{"/users/1", map[string]string{
+			"Accept": "application/vnd.acme.v1+json"}, "v1:1", true},
+		{"/v9/users/1", nil, "v3:1", false},
+		{"/v3/teams", nil, "v1:teams", true},
+		{"/status", nil, "status", false},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if body := res.Body.String(); body != test.want {
+			t.Errorf("GET %s %v got %s want %s",
+				test.path, test.headers, body, test.want)
+		}
+		deprecated := res.Header().Get("Deprecation") == "true" &&
+			res.Header().Get("Sunset") == "Tue, 01 Jan 2030 00:00:00 GMT"
+		if deprecated != test.deprecated {
+			t.Errorf("GET %s %v deprecated got %t want %t",
+				test.path, test.headers, deprecated, test.deprecated)
+		}
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	handler := func(http.ResponseWriter, *http.Request) {}
+	tests := []struct {
+		policy   Slash
+		method   string
+		path     string
+		status   int
+		location string
+	}{
+		{SlashLenient, "GET", "/foo/", http.StatusOK, ""},
+		{SlashLenient, "GET", "/bar", http.StatusOK, ""},
+		{SlashStrict, "GET", "/foo", http.StatusOK, ""},
+		{SlashStrict, "GET", "/foo/", http.StatusNotFound, ""},
+		{SlashStrict, "GET", "/bar/", http.StatusOK, ""},
+		{SlashStrict, "GET", "/bar", http.StatusNotFound, ""},
+		{SlashStrict, "GET", "/", http.StatusOK, ""},
+		{SlashRedirect, "GET", "/foo/?a=b", http.StatusMovedPermanently, "/foo?a=b"},
+		{SlashRedirect, "POST", "/baz/1", http.StatusPermanentRedirect, "/baz/1/"},
+		{SlashRedirect, "GET", "/files/a/b/", http.StatusMovedPermanently,
+			"/files/a/b"},
+	}
+	for _, test := range tests {
+		mux := New()
+		mux.TrailingSlash(test.policy)
+		mux.On("GET", "/", handler)
+		mux.On("GET", "/foo", handler)
+		mux.On("GET", "/bar/", handler)
+		mux.On("POST", "/baz/{id}/", handler)
+		mux.On("GET", "/files/*", handler)
+		mux.On("GET", "/{host}", handler)
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("%s %s (policy %d) got %d want %d",
+				test.method, test.path, test.policy, res.Code, test.status)
+		}
+		if location := res.Header().Get("Location"); location != test.location {
+			t.Errorf("%s %s (policy %d) got location %s want %s",
+				test.method, test.path, test.policy, location, test.location)
+		}
+	}
+}
+
+func TestTrailingSlashRedirectDoubleSlash(t *testing.T) {
+	var (
+		mux  *Mux = New()
+		path      = "//evil.com"
+		want      = "/evil.com/"
+	)
+	mux.TrailingSlash(SlashRedirect)
+	mux.On("GET", "/*/", func(http.ResponseWriter, *http.Request) {})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.URL.Path = path
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if location := res.Header().Get("Location"); location != want {
+		t.Errorf("GET %s got location %s want %s", path, location, want)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	handler := func(res http.ResponseWriter, req *http.Request, ctx *Context) {
+		res.Write([]byte(req.URL.Path + " " + ctx.Params["*"]))
+	}
+	tests := []struct {
+		policy   Clean
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{CleanNone, "/files/a/../../etc", http.StatusOK, "",
+			"/files/a/../../etc a/../../etc"},
+		{CleanServe, "/files/a/../../etc", http.StatusNotFound, "", ""},
+		{CleanServe, "//files///a/./b/../c/", http.StatusOK, "", "/files/a/c/ a/c"},
+		{CleanServe, "/files/../../files/a", http.StatusOK, "", "/files/a a"},
+		{CleanRedirect, "//files///a/./b/../c/?d=e", http.StatusMovedPermanently,
+			"/files/a/c/?d=e", ""},
+		{CleanRedirect, "/files/a", http.StatusOK, "", "/files/a a"},
+	}
+	for _, test := range tests {
+		mux := New()
+		mux.CleanPath(test.policy)
+		mux.On("GET", "/files/*", handler)
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.URL.Path, req.URL.RawQuery, _ = strings.Cut(test.path, "?")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s (policy %d) got %d want %d",
+				test.path, test.policy, res.Code, test.status)
+		}
+		if location := res.Header().Get("Location"); location != test.location {
+			t.Errorf("GET %s (policy %d) got location %s want %s",
+				test.path, test.policy, location, test.location)
+		}
+		if body := res.Body.String(); test.status == http.StatusOK &&
+			body != test.body {
+			t.Errorf("GET %s (policy %d) got %s want %s",
+				test.path, test.policy, body, test.body)
+		}
+	}
+}
+
+func TestMatchCase(t *testing.T) {
+	handler := func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+		res.Write([]byte(ctx.Params["id"] + ctx.Params["*"]))
+	}
+	tests := []struct {
+		policy   Case
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{CaseSensitive, "/Users/ABC/Photos", http.StatusNotFound, "", ""},
+		{CaseSensitive, "/users/ABC/photos", http.StatusOK, "", "ABC"},
+		{CaseInsensitive, "/USERS/ABC/Photos", http.StatusOK, "", "ABC"},
+		{CaseInsensitive, "/FILES/A/b", http.StatusOK, "", "A/b"},
+		{CaseRedirect, "/USERS/ABC/Photos/?x=y", http.StatusMovedPermanently,
+			"/users/ABC/photos/?x=y", ""},
+		{CaseRedirect, "/v2/Users/ABC/photos", http.StatusMovedPermanently,
+			"/v2/users/ABC/photos", ""},
+		{CaseRedirect, "/users/ABC/photos", http.StatusOK, "", "ABC"},
+	}
+	for _, test := range tests {
+		mux := New()
+		mux.MatchCase(test.policy)
+		mux.VersionBy(Versioning{Prefix: true})
+		mux.On("GET", "/users/{id}/photos", handler)
+		mux.On("GET", "/files/*", handler)
+		mux.Version(2).On("GET", "/users/{id}/photos", handler)
+		req, _ := http.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s (policy %d) got %d want %d",
+				test.path, test.policy, res.Code, test.status)
+		}
+		if location := res.Header().Get("Location"); location != test.location {
+			t.Errorf("GET %s (policy %d) got location %s want %s",
+				test.path, test.policy, location, test.location)
+		}
+		if body := res.Body.String(); test.status == http.StatusOK &&
+			body != test.body {
+			t.Errorf("GET %s (policy %d) got %s want %s",
+				test.path, test.policy, body, test.body)
+		}
+	}
+	// Captured values are never altered, even if matching folded the case of
+	// static tokens before it fell back to a wildcard.
+	mux := New()
+	mux.MatchCase(CaseRedirect)
+	mux.On("GET", "/Foo/bar", handler)
+	mux.On("GET", "/*", handler)
+	mux.On("GET", "/files/*", handler)
+	mux.On("GET", "/files/Docs/guide", handler)
+	fallbacks := []struct {
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{"/foo/baz", http.StatusOK, "", "foo/baz"},
+		{"/foo/bar", http.StatusMovedPermanently, "/Foo/bar", ""},
+		{"/files/docs/x", http.StatusOK, "", "docs/x"},
+		{"/FILES/docs/x", http.StatusMovedPermanently, "/files/docs/x", ""},
+	}
+	for _, test := range fallbacks {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s got %d want %d", test.path, res.Code, test.status)
+		}
+		if location := res.Header().Get("Location"); location != test.location {
+			t.Errorf("GET %s got location %s want %s",
+				test.path, location, test.location)
+		}
+		if body := res.Body.String(); test.status == http.StatusOK &&
+			body != test.body {
+			t.Errorf("GET %s got %s want %s", test.path, body, test.body)
+		}
+	}
+}
+
+func TestRawPath(t *testing.T) {
+	handler := func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+		res.Write([]byte(ctx.Params["name"] + "|" + ctx.Params["*"]))
+	}
+	tests := []struct {
+		raw      bool
+		path     string
+		status   int
+		location string
+		body     string
+	}{
+		{false, "/files/a%2Fb", http.StatusNotFound, "", ""},
+		{true, "/files/a%2Fb", http.StatusOK, "", "a/b|"},
+		{true, "/files/a%20b", http.StatusOK, "", "a b|"},
+		{true, "/hello%20world/a%2Fb/c", http.StatusOK, "", "|a/b/c"},
+		{true, "/Hello%20World/x", http.StatusMovedPermanently,
+			"/hello%20world/x", ""},
+		{true, "/FILES/a%2Fb/", http.StatusMovedPermanently, "/files/a%2Fb", ""},
+	}
+	for _, test := range tests {
+		mux := New()
+		mux.RawPath(test.raw)
+		mux.MatchCase(CaseRedirect)
+		mux.TrailingSlash(SlashRedirect)
+		mux.On("GET", "/files/{name}", handler)
+		mux.On("GET", "/hello world/*", handler)
+		req, _ := http.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s (raw %t) got %d want %d",
+				test.path, test.raw, res.Code, test.status)
+		}
+		if location := res.Header().Get("Location"); location != test.location {
+			t.Errorf("GET %s (raw %t) got location %s want %s",
+				test.path, test.raw, location, test.location)
+		}
+		if body := res.Body.String(); test.status == http.StatusOK &&
+			body != test.body {
+			t.Errorf("GET %s (raw %t) got %s want %s",
+				test.path, test.raw, body, test.body)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	var segments []string
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		segments = ctx.Segments()
+	}
+	tests := []struct {
+		raw    bool
+		policy Remainder
+		path   string
+		status int
+		want   []string
+	}{
+		{false, RemainderLenient, "/files/a/b/c", http.StatusOK,
+			[]string{"a", "b", "c"}},
+		{false, RemainderLenient, "/files/a/../b", http.StatusOK,
+			[]string{"a", "..", "b"}},
+		{true, RemainderLenient, "/files/a%2Fb/c%20d/", http.StatusOK,
+			[]string{"a/b", "c d"}},
+		{false, RemainderStrict, "/files/a/b", http.StatusOK,
+			[]string{"a", "b"}},
+		{false, RemainderStrict, "/files/a/../b", http.StatusNotFound, nil},
+		{false, RemainderStrict, "/files/a/./b", http.StatusNotFound, nil},
+		{false, RemainderStrict, "/files/a//b", http.StatusNotFound, nil},
+		{true, RemainderStrict, "/files/a/%2E%2E/b", http.StatusNotFound, nil},
+		{false, RemainderStrict, "/other", http.StatusOK, nil},
+		{false, RemainderLenient, "/a/../etc/passwd", http.StatusOK,
+			[]string{"a", "..", "etc", "passwd"}},
+		{false, RemainderStrict, "/a/../etc/passwd", http.StatusNotFound, nil},
+		{false, RemainderStrict, "/a/c", http.StatusOK, []string{"a", "c"}},
+	}
+	for _, test := range tests {
+		segments = nil
+		mux := New()
+		mux.RawPath(test.raw)
+		mux.WildcardRemainder(test.policy)
+		mux.On("GET", "/files/*", handler)
+		mux.On("GET", "/other", handler)
+		mux.On("GET", "/*", handler)
+		mux.On("GET", "/a/b", handler)
+		req, _ := http.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s (policy %d) got %d want %d",
+				test.path, test.policy, res.Code, test.status)
+		}
+		if !reflect.DeepEqual(segments, test.want) {
+			t.Errorf("GET %s (policy %d) got %q want %q",
+				test.path, test.policy, segments, test.want)
+		}
+	}
+}
+
+func TestOptionalParams(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		pattern      = "/list/{page?}/{format=json}"
+		params  map[string]string
+		route   string
+	)
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		params, route = ctx.Params, ctx.tree.pattern
+	}
+	if err := mux.On("GET", pattern, handler); err != nil {
+		t.Error(err)
+	}
+	if err := mux.On("GET", "/{id=1}", handler); err != nil {
+		t.Error(err)
+	}
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"/list", map[string]string{"format": "json"}},
+		{"/list/2", map[string]string{"page": "2", "format": "json"}},
+		{"/list/2/xml", map[string]string{"page": "2", "format": "xml"}},
+		{"/", map[string]string{"id": "1"}},
+		{"/2", map[string]string{"id": "2"}},
+	}
+	for _, test := range tests {
+		params, route = nil, empty
+		req, _ := http.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if !reflect.DeepEqual(params, test.want) {
+			t.Errorf("GET %s got %v want %v", test.path, params, test.want)
+		}
+		if strings.HasPrefix(test.path, "/list") &&
+			route != "/list/{page?}/{format=json}/" {
+			t.Errorf("GET %s got pattern %s", test.path, route)
+		}
+	}
+}
+
+func TestOptionalParamsRejection(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		handler      = func(http.ResponseWriter, *http.Request) {}
+	)
+	if err := mux.On("GET", "/foo/{bar?}/baz", handler); err == nil {
+		t.Errorf("optional token that is not last was accepted")
+	}
+	if err := mux.On("GET", "/foo/{bar?}/*", handler); err == nil {
+		t.Errorf("optional token followed by a wildcard was accepted")
+	}
+	mux.On("GET", "/qux", handler)
+	if err := mux.On("GET", "/qux/{page?}", handler); err == nil {
+		t.Errorf("optional token that duplicates a route was accepted")
+	}
+}
+
+func TestEnumeratedParams(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		params map[string]string
+		scoped int
+	)
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		params = ctx.Params
+	}
+	middleware := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		scoped++
+		ctx.Next()
+	}
+	if err := mux.On("GET", "/{kind:(users|teams)}/{id}", handler); err != nil {
+		t.Error(err)
+	}
+	if err := mux.On("GET", "/{kind:(users|teams)}/{id}", handler); err == nil {
+		t.Errorf("duplicate enumerated pattern was accepted")
+	}
+	if err := mux.Use("/{kind:(users|teams)}/*", middleware); err != nil {
+		t.Error(err)
+	}
+	mux.On("GET", "/{group}/{id}", handler)
+	tests := []struct {
+		path   string
+		scoped int
+		want   map[string]string
+	}{
+		{"/users/1", 1, map[string]string{"kind": "users", "id": "1"}},
+		{"/teams/2", 1, map[string]string{"kind": "teams", "id": "2"}},
+		{"/groups/3", 0, map[string]string{"group": "groups", "id": "3"}},
+	}
+	for _, test := range tests {
+		params, scoped = nil, 0
+		req, _ := http.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if scoped != test.scoped {
+			t.Errorf("GET %s scoped got %d want %d", test.path, scoped, test.scoped)
+		}
+		if !reflect.DeepEqual(params, test.want) {
+			t.Errorf("GET %s got %v want %v", test.path, params, test.want)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		method       = "GET"
+		path         = "/foo"
+		user         = NewKey[string]("user")
+		other        = NewKey[int]("user")
+		visited bool
+	)
+	always := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		user.Set(ctx, "alice").Set("user", 1).Set("temp", true)
+		other.Set(ctx, 2).Delete("temp").Next()
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = true
+		if value, ok := user.Get(ctx); !ok || value != "alice" {
+			t.Errorf("%s %s got %q (%t) want alice", method, path, value, ok)
+		}
+		if value, ok := other.Get(ctx); !ok || value != 2 {
+			t.Errorf("%s %s got %d (%t) want 2", method, path, value, ok)
+		}
+		if value := ctx.Get("user"); value != 1 {
+			t.Errorf("%s %s got %v want 1", method, path, value)
+		}
+		if ctx.Has("temp") || !ctx.Has("user") || !user.Has(ctx) {
+			t.Errorf("%s %s Has returned the wrong result", method, path)
+		}
+		want := []string{"user", "user", "user"}
+		if keys := ctx.Keys(); !reflect.DeepEqual(keys, want) {
+			t.Errorf("%s %s got keys %v want %v", method, path, keys, want)
+		}
+		user.Delete(ctx).Delete("user")
+		if user.Has(ctx) || ctx.Has("user") || !other.Has(ctx) {
+			t.Errorf("%s %s Delete removed the wrong keys", method, path)
+		}
+	}
+	mux.Always(always)
+	mux.On(method, path, handler)
+	req, _ := http.NewRequest(method, path, nil)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if !visited {
+		t.Errorf("%s %s handler was not visited", method, path)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		method       = "GET"
+		path         = "/foo"
+		body         = "created"
+		visited bool
+	)
+	always := func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+		response := ctx.Response()
+		if response.Written() || 0 != response.Status() {
+			t.Errorf("%s %s response written before handlers ran", method, path)
+		}
+		ctx.Next()
+		visited = true
+		if status := response.Status(); status != http.StatusCreated {
+			t.Errorf("%s %s got status %d want %d",
+				method, path, status, http.StatusCreated)
+		}
+		if size := response.BytesWritten(); size != int64(len(body)) {
+			t.Errorf("%s %s got size %d want %d", method, path, size, len(body))
+		}
+		if !response.Written() || 0 >= response.TimeToFirstByte() {
+			t.Errorf("%s %s response was not recorded", method, path)
+		}
+	}
+	handler := func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+		if _, ok := res.(http.Flusher); !ok {
+			t.Errorf("%s %s response is not an http.Flusher", method, path)
+		}
+		res.WriteHeader(http.StatusCreated)
+		io.Copy(res, strings.NewReader(body))
+	}
+	mux.Always(always)
+	mux.On(method, path, handler)
+	req, _ := http.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if !visited {
+		t.Errorf("%s %s Always handler was not visited", method, path)
+	}
+	if res.Code != http.StatusCreated || res.Body.String() != body {
+		t.Errorf("%s %s got %d %q want %d %q",
+			method, path, res.Code, res.Body.String(), http.StatusCreated, body)
+	}
+}
+
+func TestResponseDefaultStatus(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "GET"
+		path        = "/foo"
+		status int
+	)
+	always := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		ctx.Next()
+		status = ctx.Response().Status()
+	}
+	handler := func(res http.ResponseWriter, _ *http.Request, _ *Context) {
+		http.NewResponseController(res).Flush()
+		res.Write([]byte("ok"))
+	}
+	mux.Always(always)
+	mux.On(method, path, handler)
+	req, _ := http.NewRequest(method, path, nil)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if status != http.StatusOK {
+		t.Errorf("%s %s got status %d want %d", method, path, status, http.StatusOK)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var (
+		mux   *Mux = New()
+		tests      = []struct {
+			path        string
+			handler     func(*Context)
+			status      int
+			contentType string
+			body        string
+		}{
+			{"/blob", func(ctx *Context) {
+				ctx.Blob(http.StatusOK, "image/gif", []byte("GIF89a"))
+			}, http.StatusOK, "image/gif", "GIF89a"},
+			{"/html", func(ctx *Context) {
+				ctx.HTML(http.StatusOK, "<p>hi</p>")
+			}, http.StatusOK, "text/html; charset=utf-8", "<p>hi</p>"},
+			{"/json", func(ctx *Context) {
+				ctx.JSON(http.StatusCreated, map[string]int{"id": 1})
+			}, http.StatusCreated, "application/json; charset=utf-8", `{"id":1}`},
+			{"/none", func(ctx *Context) {
+				ctx.NoContent(http.StatusNoContent)
+			}, http.StatusNoContent, "", ""},
+			{"/stream", func(ctx *Context) {
+				ctx.Stream(http.StatusOK, "text/csv", strings.NewReader("a,b"))
+			}, http.StatusOK, "text/csv", "a,b"},
+			{"/text", func(ctx *Context) {
+				ctx.Text(http.StatusAccepted, "queued")
+			}, http.StatusAccepted, "text/plain; charset=utf-8", "queued"},
+		}
+	)
+	for _, test := range tests {
+		mux.On("GET", test.path, test.handler)
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s got %d want %d", test.path, res.Code, test.status)
+		}
+		if contentType := res.Header().Get("Content-Type"); contentType != test.contentType {
+			t.Errorf("GET %s got %q want %q", test.path, contentType, test.contentType)
+		}
+		if body := res.Body.String(); body != test.body {
+			t.Errorf("GET %s got %q want %q", test.path, body, test.body)
+		}
+	}
+}
+
+func TestRenderRedirect(t *testing.T) {
+	var (
+		mux      *Mux = New()
+		method        = "GET"
+		path          = "/old"
+		location      = "/new"
+	)
+	mux.On(method, path, func(ctx *Context) {
+		ctx.Redirect(http.StatusFound, location)
+	})
+	req, _ := http.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusFound || res.Header().Get("Location") != location {
+		t.Errorf("%s %s got %d %q want %d %q", method, path,
+			res.Code, res.Header().Get("Location"), http.StatusFound, location)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	var (
+		mux       *Mux = New()
+		method         = "GET"
+		path           = "/foo"
+		committed bool
+		failure   error
+	)
+	always := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		ctx.Next()
+		committed = ctx.Committed()
+	}
+	mux.OnError(func(ctx *Context, status int, err error) {
+		failure = err
+		ctx.Text(status, "oops")
+	})
+	mux.Always(always)
+	mux.On(method, path, func(ctx *Context) {
+		ctx.JSON(http.StatusOK, func() {}) // functions cannot be encoded
+	})
+	req, _ := http.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusInternalServerError || res.Body.String() != "oops" {
+		t.Errorf("%s %s got %d %q want %d %q", method, path, res.Code,
+			res.Body.String(), http.StatusInternalServerError, "oops")
+	}
+	if nil == failure {
+		t.Errorf("%s %s error handler did not receive the encoding error",
+			method, path)
+	}
+	if !committed {
+		t.Errorf("%s %s response was not committed", method, path)
+	}
+}
+
+type bindAddress struct {
+	City string `json:"city"`
+	Zip  int    `json:"zip"`
+}
+
+type bindRequest struct {
+	ID      int           `path:"id" json:"-"`
+	Page    int           `query:"page" default:"1" json:"-"`
+	Tags    []string      `query:"tag" json:"-"`
+	Timeout time.Duration `query:"timeout" default:"5s" json:"-"`
+	Token   *string       `header:"X-Token" json:"-"`
+	Name    string        `json:"name"`
+	Address bindAddress   `json:"address"`
+}
+
+func TestBind(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "POST"
+		path        = "/users/42?tag=a&tag=b&timeout=1m"
+		body        = `{"name":"alice","address":{"city":"Paris","zip":75001}}`
+		got    bindRequest
+	)
+	mux.On(method, "/users/{id}", func(ctx *Context) {
+		if err := ctx.Bind(&got); err != nil {
+			t.Errorf("%s %s got error %v", method, path, err)
+		}
+	})
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Token", "secret")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if nil == got.Token || *got.Token != "secret" {
+		t.Errorf("%s %s got token %v want secret", method, path, got.Token)
+	}
+	got.Token = nil
+	want := bindRequest{
+		ID:      42,
+		Page:    1,
+		Tags:    []string{"a", "b"},
+		Timeout: time.Minute,
+		Name:    "alice",
+		Address: bindAddress{City: "Paris", Zip: 75001}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s %s got %+v want %+v", method, path, got, want)
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "POST"
+		path        = "/login"
+		got    struct {
+			User     string `form:"user"`
+			Remember bool   `form:"remember"`
+		}
+	)
+	mux.On(method, path, func(ctx *Context) { ctx.Bind(&got) })
+	form := strings.NewReader("user=alice&remember=true")
+	req, _ := http.NewRequest(method, path, form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if got.User != "alice" || !got.Remember {
+		t.Errorf("%s %s got %+v", method, path, got)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "GET"
+		path        = "/users/abc?page=x"
+		errs   FieldErrors
+		status int
+	)
+	mux.OnError(func(ctx *Context, code int, err error) {
+		status, _ = code, errors.As(err, &errs)
+	})
+	mux.On(method, "/users/{id}", func(ctx *Context) {
+		var dst bindRequest
+		ctx.Bind(&dst)
+	})
+	req, _ := http.NewRequest(method, path, nil)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if status != http.StatusBadRequest {
+		t.Errorf("%s %s got %d want %d", method, path, status, http.StatusBadRequest)
+	}
+	fields := []string{}
+	for _, err := range errs {
+		fields = append(fields, err.Source+" "+err.Field)
+	}
+	if want := []string{"path id", "query page"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("%s %s got %v want %v", method, path, fields, want)
+	}
+}
+
+type validateItem struct {
+	SKU      string `json:"sku" validate:"required,regexp=^[A-Z]{3}-\\d+$"`
+	Quantity int    `json:"quantity" validate:"min=1,max=10"`
+}
+
+type validateOrder struct {
+	Customer string         `json:"customer" validate:"required,len=4"`
+	Status   string         `json:"status" validate:"enum=open|closed"`
+	Note     string         `json:"note" validate:"max=5"`
+	Items    []validateItem `json:"items" validate:"required"`
+}
+
+func TestValidate(t *testing.T) {
+	var (
+		valid = validateOrder{
+			Customer: "acme",
+			Status:   "open",
+			Items:    []validateItem{{SKU: "ABC-1", Quantity: 2}}}
+		invalid = validateOrder{
+			Status: "lost",
+			Note:   "too long",
+			Items:  []validateItem{{SKU: "ABC-1", Quantity: 1}, {SKU: "x"}}}
+		want = []string{
+			"customer: is required",
+			"status: must be one of open, closed",
+			"note: must have a length of at most 5",
+			"items[1].sku: must match ^[A-Z]{3}-\\d+$",
+			"items[1].quantity: must be at least 1",
+		}
+	)
+	if err := Validate(valid); err != nil {
+		t.Errorf("Validate(%+v) got %v want nil", valid, err)
+	}
+	var errs FieldErrors
+	if err := Validate(&invalid); !errors.As(err, &errs) {
+		t.Fatalf("Validate(%+v) got %v want FieldErrors", invalid, err)
+	}
+	got := []string{}
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate(%+v) got %q want %q", invalid, got, want)
+	}
+	zero := struct {
+		Count int `validate:"min=1"`
+	}{0}
+	if err := Validate(zero); !errors.As(err, &errs) {
+		t.Errorf("Validate(%+v) got %v want FieldErrors", zero, err)
+	}
+	omitted := struct {
+		Count *int `validate:"min=1"`
+	}{}
+	if err := Validate(omitted); err != nil {
+		t.Errorf("Validate(%+v) got %v want nil", omitted, err)
+	}
+	bad := struct {
+		Name string `validate:"shiny"`
+	}{"x"}
+	if err := Validate(bad); nil == err || errors.As(err, &errs) {
+		t.Errorf("Validate(%+v) got %v want rule error", bad, err)
+	}
+}
+
+func TestValidated(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "POST"
+		path        = "/orders"
+		order       = NewKey[validateOrder]("order")
+		tests       = []struct {
+			body   string
+			status int
+		}{
+			{`{"customer":"acme","status":"open",` +
+				`"items":[{"sku":"ABC-1","quantity":1}]}`, http.StatusCreated},
+			{`{"customer":"acme","items":[{"sku":"ABC-1","quantity":1}]}`,
+				http.StatusUnprocessableEntity},
+			{`{"customer":"acme","items":[]}`, http.StatusUnprocessableEntity},
+			{`{"customer":5}`, http.StatusBadRequest},
+		}
+	)
+	mux.On(method, path, Validated(order), func(ctx *Context) {
+		if value, ok := order.Get(ctx); ok && value.Customer == "acme" {
+			ctx.NoContent(http.StatusCreated)
+		}
+	})
+	for _, test := range tests {
+		req, _ := http.NewRequest(method, path, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("%s %s %s got %d want %d",
+				method, path, test.body, res.Code, test.status)
+		}
+	}
+}
+
+func TestProblem(t *testing.T) {
+	var (
+		mux   *Mux = New()
+		tests      = []struct {
+			path   string
+			accept string
+			status int
+			body   string
+		}{
+			{"/missing", "", http.StatusNotFound, "Not Found\n"},
+			{"/missing", "application/json", http.StatusNotFound,
+				`{"instance":"/missing","status":404,"title":"Not Found"}`},
+			{"/teapot", "application/problem+json", http.StatusTeapot,
+				`{"detail":"short and stout","instance":"/teapot","status":418,` +
+					`"title":"I'm a teapot","type":"https://example.com/teapot"}`},
+			{"/secret", "", http.StatusInternalServerError,
+				"Internal Server Error\n"},
+			{"/users/x", "application/json", http.StatusBadRequest,
+				`{"detail":"path id: \"x\" is not a valid int","errors":[{"field":"id",` +
+					`"source":"path","message":"\"x\" is not a valid int"}],` +
+					`"instance":"/users/x","status":400,"title":"Bad Request"}`},
+		}
+	)
+	mux.OnProblem(func(ctx *Context, problem *Problem) {
+		problem.Instance = ctx.Request.URL.Path
+	})
+	mux.On("GET", "/teapot", func(*Context) error {
+		return &Problem{Type: "https://example.com/teapot",
+			Status: http.StatusTeapot, Detail: "short and stout"}
+	})
+	mux.On("GET", "/secret", func(*Context) error {
+		return errors.New("database password is hunter2")
+	})
+	mux.On("GET", "/users/{id}", func(ctx *Context) error {
+		var dst struct {
+			ID int `path:"id"`
+		}
+		return ctx.Bind(&dst)
+	})
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.path, nil)
+		if test.accept != empty {
+			req.Header.Set("Accept", test.accept)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s got %d want %d", test.path, res.Code, test.status)
+		}
+		if body := strings.TrimSpace(res.Body.String()); body != strings.TrimSpace(test.body) {
+			t.Errorf("GET %s got %s want %s", test.path, body, test.body)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "DELETE"
+		path        = "/users/5"
+	)
+	mux.MethodNotAllowed(true)
+	mux.On("GET", "/users/{id}", func(*Context) {})
+	mux.On("PUT", "/users/{id}", func(*Context) {})
+	req, _ := http.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("%s %s got %d want %d",
+			method, path, res.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := res.Header().Get("Allow"); allow != "GET, PUT" {
+		t.Errorf("%s %s got Allow %q want %q", method, path, allow, "GET, PUT")
+	}
+	req, _ = http.NewRequest(method, "/posts/5", nil)
+	res = httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("%s /posts/5 got %d want %d", method, res.Code, http.StatusNotFound)
+	}
+}
+
+func TestMethodNotAllowedOwnVerb(t *testing.T) {
+	var (
+		mux   *Mux = New()
+		tests      = []struct {
+			method string
+			path   string
+			status int
+			allow  string
+		}{
+			{"GET", "/foo/", http.StatusNotFound, ""},
+			{"GET", "/bar", http.StatusNotFound, ""},
+			{"POST", "/bar", http.StatusMethodNotAllowed, "GET"},
+		}
+	)
+	mux.MethodNotAllowed(true)
+	mux.TrailingSlash(SlashStrict)
+	mux.On("GET", "/foo", func(*Context) {})
+	mux.On("GET", "/bar", Header("X-Token", ""), func(*Context) {})
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("%s %s got %d want %d",
+				test.method, test.path, res.Code, test.status)
+		}
+		if allow := res.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s got Allow %q want %q",
+				test.method, test.path, allow, test.allow)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		method       = "GET"
+		path         = "/foo"
+		visited bool
+	)
+	mux.Recover(true)
+	mux.After(func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = ctx.Response().Status() == http.StatusInternalServerError
+	})
+	mux.On(method, path, func(*Context) { panic("handler panic") })
+	req, _ := http.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("%s %s got %d want %d",
+			method, path, res.Code, http.StatusInternalServerError)
+	}
+	if !visited {
+		t.Errorf("%s %s After handler did not see the error response", method, path)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	var (
+		req   *http.Request
+		ctx   *Context
+		tests = []struct {
+			header string
+			value  string
+			offers []string
+			want   string
+		}{
+			{"Accept", "text/html, application/json;q=0.9",
+				[]string{"application/json", "text/html"}, "text/html"},
+			{"Accept", "text/*;q=0.5, text/plain",
+				[]string{"text/css", "text/plain"}, "text/plain"},
+			{"Accept", "*/*;q=0.1, application/json;q=0",
+				[]string{"application/json", "text/csv"}, "text/csv"},
+			{"Accept", "image/png", []string{"text/html"}, ""},
+			{"Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5",
+				[]string{"de", "en-US", "fr"}, "fr"},
+			{"Accept-Language", "en;q=0.8, en-GB",
+				[]string{"en-US", "en-GB"}, "en-GB"},
+			{"Accept-Encoding", "gzip;q=0.5, br",
+				[]string{"gzip", "br"}, "br"},
+			{"Accept-Encoding", "gzip",
+				[]string{"deflate", "identity"}, "identity"},
+			{"Accept-Encoding", "*;q=0", []string{"identity"}, ""},
+			{"Accept-Charset", "iso-8859-1, utf-8;q=0.7",
+				[]string{"UTF-8", "ISO-8859-1"}, "ISO-8859-1"},
+		}
+	)
+	for _, test := range tests {
+		req, _ = http.NewRequest("GET", "/", nil)
+		req.Header.Set(test.header, test.value)
+		ctx = &Context{Request: req}
+		negotiate := map[string]func(...string) string{
+			"Accept":          ctx.Negotiate,
+			"Accept-Charset":  ctx.NegotiateCharset,
+			"Accept-Encoding": ctx.NegotiateEncoding,
+			"Accept-Language": ctx.NegotiateLanguage,
+		}[test.header]
+		if got := negotiate(test.offers...); got != test.want {
+			t.Errorf("%s: %s %v got %q want %q",
+				test.header, test.value, test.offers, got, test.want)
+		}
+	}
+}
+
+func TestProduces(t *testing.T) {
+	var (
+		mux   *Mux = New()
+		path       = "/report"
+		tests      = []struct {
+			accept string
+			status int
+			body   string
+		}{
+			{"", http.StatusOK, "application/json"},
+			{"text/csv, application/json;q=0.5", http.StatusOK, "text/csv"},
+			{"image/png", http.StatusNotAcceptable, ""},
+		}
+	)
+	mux.On("GET", path, Produces("application/json", "text/csv"),
+		func(ctx *Context) { ctx.Text(http.StatusOK, ctx.Negotiate()) })
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", path, nil)
+		if test.accept != empty {
+			req.Header.Set("Accept", test.accept)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("GET %s (%s) got %d want %d",
+				path, test.accept, res.Code, test.status)
+		}
+		if body := res.Body.String(); test.status == http.StatusOK && body != test.body {
+			t.Errorf("GET %s (%s) got %q want %q", path, test.accept, body, test.body)
+		}
+	}
+}
+
+func TestMeta(t *testing.T) {
+	var (
+		mux   *Mux = New()
+		meta  Meta
+		found string
+		tests = []struct {
+			method  string
+			path    string
+			pattern string
+			meta    Meta
+		}{
+			{"GET", "/users/5", "/users/{id}", Meta{"scope": "read", "team": "core"}},
+			{"DELETE", "/users/5", "/users/{id}/", Meta{"scope": "admin"}},
+			{"GET", "/files/a/b", "/files/*", nil},
+			{"GET", "/missing", "", nil},
+		}
+	)
+	always := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		meta, found = ctx.Meta(), ctx.Pattern()
+		ctx.Next()
+	}
+	handler := func(*Context) {}
+	mux.Always(always)
+	mux.NotFound(handler)
+	mux.On("GET", "users/{id}", Meta{"scope": "admin", "team": "core"},
+		Meta{"scope": "read"}, handler)
+	mux.On("DELETE", "//users/{id}/", Header("X-Confirm", ""),
+		Meta{"scope": "admin"}, handler)
+	mux.On("GET", "/files/*", handler)
+	for _, test := range tests {
+		meta, found = nil, "?"
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		req.Header.Set("X-Confirm", "yes")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		if found != test.pattern {
+			t.Errorf("%s %s got pattern %q want %q",
+				test.method, test.path, found, test.pattern)
+		}
+		if !reflect.DeepEqual(meta, test.meta) {
+			t.Errorf("%s %s got meta %v want %v",
+				test.method, test.path, meta, test.meta)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	var (
+		mux   *Mux = New()
+		route Route
+		tests = []struct {
+			method string
+			path   string
+			want   Route
+		}{
+			{"GET", "/users/5", Route{"/users/{id}", "GET", false}},
+			{"PUT", "/users/5", Route{"/users/{id}", "PUT", false}},
+			{"GET", "/users/5/avatar", Route{"/users/*", "GET", true}},
+			{"GET", "/v1/orders/", Route{"/orders/", "GET", false}},
+			{"POST", "/missing", Route{}},
+		}
+	)
+	always := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		route = ctx.Route()
+	}
+	handler := func(*Context) {}
+	mux.Always(always)
+	mux.NotFound(handler)
+	mux.On("*", "/users/{id}", handler)
+	mux.On("GET", "/users/*", handler)
+	mux.VersionBy(Versioning{Prefix: true})
+	mux.Version(1).On("GET", "/orders/", handler)
+	for _, test := range tests {
+		route = Route{Pattern: "?"}
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		if route != test.want {
+			t.Errorf("%s %s got %+v want %+v", test.method, test.path, route, test.want)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		always int
+		tests  = []struct {
+			path string
+			want string
+		}{
+			{"/alias/5", "user 5 (GET /users/{id}) alice"},
+			{"/alias/a%2Fb?format=csv", "user a/b (GET /users/{id}) alice csv"},
+			{"/isolated/5", "user 5 (GET /users/{id}) "},
+			{"/files/docs/a.txt", "file docs/a.txt"},
+		}
+	)
+	mux.RawPath(true)
+	mux.Always(func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		always++
+		ctx.Next()
+	})
+	mux.On("GET", "/users/{id}", func(ctx *Context) {
+		name, _ := ctx.Get("name").(string)
+		body := fmt.Sprintf("user %s (%s %s) %s", ctx.Params["id"],
+			ctx.Route().Verb, ctx.Pattern(), name)
+		if format := ctx.Request.URL.Query().Get("format"); format != empty {
+			body += " " + format
+		}
+		ctx.Set("seen", true).Text(http.StatusOK, body)
+	})
+	mux.On("GET", "/storage/{bucket}/*", func(ctx *Context) {
+		ctx.Text(http.StatusOK, ctx.Params["bucket"]+" "+ctx.Params["*"])
+	})
+	mux.On("POST", "/alias/{id}", func(ctx *Context) {
+		ctx.Set("name", "alice")
+		ctx.ForwardShared("GET", "/users/{id}", ctx.Params)
+		if !ctx.Has("seen") {
+			t.Errorf("POST %s state was not shared", ctx.Request.URL.Path)
+		}
+	})
+	mux.On("POST", "/isolated/{id}", func(ctx *Context) {
+		ctx.Set("name", "alice")
+		ctx.Forward("GET", "/users/{id}", ctx.Params)
+	})
+	mux.On("POST", "/files/*", func(ctx *Context) {
+		ctx.Forward("GET", "/storage/{bucket}/*", map[string]string{
+			"bucket": "file", "*": strings.Join(ctx.Segments(), "/")})
+	})
+	for _, test := range tests {
+		always = 0
+		req, _ := http.NewRequest("POST", test.path, nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if body := res.Body.String(); res.Code != http.StatusOK || body != test.want {
+			t.Errorf("POST %s got %d %q want %d %q",
+				test.path, res.Code, body, http.StatusOK, test.want)
+		}
+		if always != 2 {
+			t.Errorf("POST %s Always ran %d times want 2", test.path, always)
+		}
+	}
+}
+
+func TestForwardHost(t *testing.T) {
+	var (
+		mux  *Mux = New()
+		host      = "acme.example.com"
+		path      = "/a"
+		want      = "acme"
+	)
+	tenant := mux.Host("{tenant}.example.com")
+	tenant.On("GET", "/a", func(ctx *Context) {
+		ctx.Forward("GET", "/b", nil)
+	})
+	tenant.On("GET", "/b", func(ctx *Context) {
+		ctx.Text(http.StatusOK, ctx.Params["tenant"])
+	})
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Host = host
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if body := res.Body.String(); body != want {
+		t.Errorf("GET %s%s got %q want %q", host, path, body, want)
+	}
+}
+
+func TestForwardRejection(t *testing.T) {
+	var (
+		mux  *Mux = New()
+		path      = "/foo"
+		errs []error
+	)
+	mux.On("GET", path, func(ctx *Context) {
+		errs = append(errs, ctx.Forward("BLUB", "/bar", nil))
+		errs = append(errs, ctx.Forward("GET", "/bar/{id}", nil))
+		errs = append(errs, ctx.Forward("GET", "/bar/*", nil))
+	})
+	req, _ := http.NewRequest("GET", path, nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	for index, err := range errs {
+		if nil == err {
+			t.Errorf("GET %s forward %d should have failed", path, index)
+		}
+	}
+	if res.Code != http.StatusOK || res.Body.Len() != 0 {
+		t.Errorf("GET %s failed forwards should not respond", path)
+	}
+}
+
+func TestUseOverlap(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		method       = "GET"
+		path         = "/admin/x"
+		want         = "admin,tenant,handler"
+		visited []string
+	)
+	record := func(label string) HandlerFunc {
+		return func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+			visited = append(visited, label)
+			ctx.Next()
+		}
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "handler")
+		if ctx.Params["user"] != "admin" || ctx.Params["page"] != "x" {
+			t.Errorf("%s %s got params %v", method, path, ctx.Params)
+		}
+	}
+	mux.On(method, "/{user}/{page}", handler)
+	mux.Use("/admin", record("admin"))
+	mux.Use("/{tenant}", record("tenant"))
+	req, _ := http.NewRequest(method, path, nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("%s %s got %d want %d", method, path, res.Code, http.StatusOK)
+	}
+	if got := strings.Join(visited, ","); got != want {
+		t.Errorf("%s %s got %s want %s", method, path, got, want)
+	}
+}
+
+func TestAfterResponses(t *testing.T) {
+	var (
+		mux   *Mux = New()
+		after int
+		tests = []struct {
+			method string
+			path   string
+			status int
+		}{
+			{"GET", "/missing", http.StatusNotFound},
+			{"POST", "/users", http.StatusMethodNotAllowed},
+			{"GET", "/reports", http.StatusNotAcceptable},
+			{"GET", "/users/", http.StatusMovedPermanently},
+			{"GET", "/Users", http.StatusMovedPermanently},
+			{"GET", "/a/../users", http.StatusMovedPermanently},
+		}
+	)
+	mux.After(func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		after++
+	})
+	mux.MethodNotAllowed(true)
+	mux.CleanPath(CleanRedirect)
+	mux.MatchCase(CaseRedirect)
+	mux.TrailingSlash(SlashRedirect)
+	mux.On("GET", "/users", func(*Context) {})
+	mux.On("GET", "/reports", Produces("text/csv"), func(*Context) {})
+	for _, test := range tests {
+		after = 0
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		req.Header.Set("Accept", "application/json")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		if res.Code != test.status {
+			t.Errorf("%s %s got %d want %d",
+				test.method, test.path, res.Code, test.status)
+		}
+		if after != 1 {
+			t.Errorf("%s %s After ran %d times want 1",
+				test.method, test.path, after)
+		}
+	}
+}
+
+func TestVersionScopes(t *testing.T) {
+	var (
+		mux     *Mux = New()
+		want         = "auth,v2,handler"
+		visited []string
+	)
+	record := func(label string) HandlerFunc {
+		return func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+			visited = append(visited, label)
+			ctx.Next()
+		}
+	}
+	handler := func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		visited = append(visited, "handler")
+	}
+	mux.MethodNotAllowed(true)
+	mux.VersionBy(Versioning{Prefix: true})
+	mux.Use("/users", record("auth"))
+	mux.Version(2).Use("/users", record("v2"))
+	mux.Version(2).On("GET", "/users", handler)
+	mux.Version(1).On("POST", "/users", handler)
+	for _, path := range []string{"/v2/users", "/users"} {
+		visited = nil
+		req, _ := http.NewRequest("GET", path, nil)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		if got := strings.Join(visited, ","); got != want {
+			t.Errorf("GET %s got %s want %s", path, got, want)
+		}
+	}
+	req, _ := http.NewRequest("GET", "/v1/users", nil)
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /v1/users got %d want %d",
+			res.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := res.Header().Get("Allow"); allow != "POST" {
+		t.Errorf("GET /v1/users got Allow %s want POST", allow)
+	}
+}
+
+// plainWriter is an http.ResponseWriter without any optional interfaces.
+type plainWriter struct {
+	header http.Header
+	status int
+}
+
+func (res *plainWriter) Header() http.Header            { return res.header }
+func (res *plainWriter) Write(data []byte) (int, error) { return len(data), nil }
+func (res *plainWriter) WriteHeader(status int)         { res.status = status }
+
+func TestResponseInterfaces(t *testing.T) {
+	var (
+		mux    *Mux = New()
+		method      = "GET"
+		path        = "/events"
+		err    error
+	)
+	mux.On(method, path, func(res http.ResponseWriter, _ *http.Request, ctx *Context) {
+		if _, ok := res.(http.Flusher); ok {
+			t.Errorf("%s %s response should not be an http.Flusher", method, path)
+		}
+		if _, ok := res.(http.Hijacker); ok {
+			t.Errorf("%s %s response should not be an http.Hijacker", method, path)
+		}
+		if _, ok := res.(http.Pusher); ok {
+			t.Errorf("%s %s response should not be an http.Pusher", method, path)
+		}
+		err = http.NewResponseController(res).Flush()
+		ctx.Text(http.StatusOK, "data")
+	})
+	req, _ := http.NewRequest(method, path, nil)
+	res := &plainWriter{header: make(http.Header)}
+	mux.ServeHTTP(res, req)
+	if !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("%s %s got flush error %v want %v",
+			method, path, err, http.ErrNotSupported)
+	}
+	if res.status != http.StatusOK {
+		t.Errorf("%s %s got %d want %d", method, path, res.status, http.StatusOK)
+	}
+}
@@ -6,22 +6,31 @@
 // request contexts to form the nucleus of a middleware-based web service.
 package bear
 
-import "regexp"
+import (
+	"encoding"
+	"reflect"
+	"regexp"
+	"time"
+)
 
 const (
-	asterisk  = "*"
-	dynamic   = "\x00"
-	empty     = ""
-	lasterisk = "*/"
-	slash     = "/"
-	slashr    = '/'
-	wildcard  = "\x00\x00"
+	asterisk   = "*"
+	dynamic    = "\x00"
+	empty      = ""
+	enumerated = "\x01"
+	lasterisk  = "*/"
+	slash      = "/"
+	slashr     = '/'
+	wildcard   = "\x00\x00"
 )
 
 var (
-	dyn   = regexp.MustCompile(`\{(\w+)\}`)
-	dbl   = regexp.MustCompile(`[\/]{2,}`)
-	verbs = [8]string{
+	dyn           = regexp.MustCompile(`\{(\w+)(?::\(([^(){}/]+)\))?(\?|=([^{}/]*))?\}`)
+	dbl           = regexp.MustCompile(`[\/]{2,}`)
+	ver           = regexp.MustCompile(`^/v(\d+)(/|$)`)
+	duration      = reflect.TypeOf(time.Duration(0))
+	textUnmarshal = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	verbs         = [8]string{
 		"CONNECT",
 		"DELETE",
 		"GET",
@@ -0,0 +1,268 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError describes a request value that could not be bound to (or that
+// failed the validation of) a struct field.
+type FieldError struct {
+	Field   string `json:"field"`            // path, e.g. "page" or "address.zip"
+	Source  string `json:"source,omitempty"` // origin, e.g. "query" or "json"
+	Message string `json:"message"`          // what went wrong
+}
+
+func (err *FieldError) Error() string {
+	if err.Source == empty {
+		return fmt.Sprintf("%s: %s", err.Field, err.Message)
+	}
+	return fmt.Sprintf("%s %s: %s", err.Source, err.Field, err.Message)
+}
+
+// FieldErrors is a list of field errors that is itself an error.
+type FieldErrors []*FieldError
+
+func (errs FieldErrors) Error() string {
+	messages := make([]string, len(errs))
+	for index, err := range errs {
+		messages[index] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// binding is the state of a single call to (*Context).Bind.
+type binding struct {
+	ctx   *Context
+	errs  FieldErrors
+	form  map[string][]string
+	query map[string][]string
+	phase int // bindDefaults or bindValues
+}
+
+const (
+	bindDefaults = iota
+	bindValues
+)
+
+// Bind populates the fields of the struct that dst points to with the values
+// of the request, as directed by the struct tags of each field:
+//
+//	path:"id"         the param "id" of ctx.Params
+//	query:"page"      the query string value(s) of "page"
+//	header:"X-Token"  the header value(s) of "X-Token"
+//	form:"name"       the value(s) of "name" in a form request body
+//	default:"10"      the value of the field if the request does not supply one
+//
+// A request body whose content type is JSON is decoded into dst, so fields
+// with json tags are populated as well. Values are converted to the type of
+// their field, which can be a string, a boolean, a number, a time.Duration, a
+// type that implements encoding.TextUnmarshaler, or a pointer to or a slice of
+// any of those. Untagged struct fields are bound recursively.
+//
+// If any values cannot be bound, Bind returns FieldErrors that list all of them
+// and passes the error to (*Context).Error with the status 400 Bad Request. If
+// dst is not a pointer to a struct, the error is passed on with the status 500
+// Internal Server Error instead. Either way, the handler should return.
+func (ctx *Context) Bind(dst interface{}) error {
+	value := reflect.ValueOf(dst)
+	if value.Kind() != reflect.Ptr || value.IsNil() ||
+		value.Elem().Kind() != reflect.Struct {
+		err := fmt.Errorf("bear: Bind requires a pointer to a struct, not %T", dst)
+		ctx.Error(http.StatusInternalServerError, err)
+		return err
+	}
+	b := &binding{ctx: ctx, query: ctx.Request.URL.Query()}
+	b.walk(value.Elem(), empty)
+	b.decode(dst)
+	b.phase = bindValues
+	b.walk(value.Elem(), empty)
+	if 0 < len(b.errs) {
+		ctx.Error(http.StatusBadRequest, b.errs)
+		return b.errs
+	}
+	return nil
+}
+
+// decode decodes the request body into dst if it is JSON, or parses it if it is
+// a form.
+func (b *binding) decode(dst interface{}) {
+	req := b.ctx.Request
+	if nil == req.Body || http.NoBody == req.Body {
+		return
+	}
+	media, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case media == "application/json" || strings.HasSuffix(media, "+json"):
+		err := json.NewDecoder(req.Body).Decode(dst)
+		var typed *json.UnmarshalTypeError
+		if errors.As(err, &typed) {
+			b.fail("json", typed.Field, "must be of type "+typed.Type.String())
+		} else if nil != err && err != io.EOF {
+			b.fail("json", empty, err.Error())
+		}
+	case media == "application/x-www-form-urlencoded":
+		if err := req.ParseForm(); err != nil {
+			b.fail("form", empty, err.Error())
+		}
+		b.form = req.PostForm
+	case media == "multipart/form-data":
+		if err := req.ParseMultipartForm(32 << 20); err != nil {
+			b.fail("form", empty, err.Error())
+		} else {
+			b.form = req.MultipartForm.Value
+		}
+	}
+}
+
+// fail records that the value of field from source could not be bound.
+func (b *binding) fail(source string, field string, message string) {
+	if field == empty {
+		field = "body"
+	}
+	b.errs = append(b.errs, &FieldError{field, source, message})
+}
+
+// lookup returns the request values of a struct field according to its tags,
+// along with the name and the source of the values. The source is empty if the
+// field has none of the tags that Bind recognizes.
+func (b *binding) lookup(field reflect.StructField) ([]string, string, string) {
+	if name, ok := field.Tag.Lookup("path"); ok {
+		if value, ok := b.ctx.Params[name]; ok {
+			return []string{value}, name, "path"
+		}
+		return nil, name, "path"
+	}
+	if name, ok := field.Tag.Lookup("query"); ok {
+		return b.query[name], name, "query"
+	}
+	if name, ok := field.Tag.Lookup("header"); ok {
+		return b.ctx.Request.Header.Values(name), name, "header"
+	}
+	if name, ok := field.Tag.Lookup("form"); ok {
+		return b.form[name], name, "form"
+	}
+	return nil, label(field), empty
+}
+
+// label returns the name of a struct field in a JSON body, or its Go name if it
+// has no such name.
+func label(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == empty || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// walk binds the fields of the struct value. Field names are prefixed with
+// prefix, i.e. the path of the struct itself.
+func (b *binding) walk(value reflect.Value, prefix string) {
+	for index := 0; index < value.NumField(); index++ {
+		field, target := value.Type().Field(index), value.Field(index)
+		if !target.CanSet() {
+			continue
+		}
+		values, name, source := b.lookup(field)
+		if source == empty && target.Kind() == reflect.Struct &&
+			!reflect.PtrTo(target.Type()).Implements(textUnmarshal) {
+			if field.Anonymous {
+				b.walk(target, prefix)
+			} else {
+				b.walk(target, prefix+name+".")
+			}
+			continue
+		}
+		if b.phase == bindDefaults {
+			if fallback, ok := field.Tag.Lookup("default"); ok {
+				if err := convert([]string{fallback}, target); err != nil {
+					b.fail("default", prefix+name, err.Error())
+				}
+			}
+			continue
+		}
+		if source == empty || 0 == len(values) {
+			continue
+		}
+		if err := convert(values, target); err != nil {
+			b.fail(source, prefix+name, err.Error())
+		}
+	}
+}
+
+// convert sets target to values, converted to the type of target. Only slices
+// receive more than the first value.
+func convert(values []string, target reflect.Value) error {
+	if reflect.PtrTo(target.Type()).Implements(textUnmarshal) {
+		unmarshaler := target.Addr().Interface().(encoding.TextUnmarshaler)
+		return unmarshaler.UnmarshalText([]byte(values[0]))
+	}
+	value := values[0]
+	switch target.Kind() {
+	case reflect.Ptr:
+		pointer := reflect.New(target.Type().Elem())
+		if err := convert(values, pointer.Elem()); err != nil {
+			return err
+		}
+		target.Set(pointer)
+	case reflect.Slice:
+		slice := reflect.MakeSlice(target.Type(), len(values), len(values))
+		for index := range values {
+			if err := convert(values[index:index+1], slice.Index(index)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		target.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if target.Type() == duration {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a duration", value)
+			}
+			target.SetInt(int64(parsed))
+			return nil
+		}
+		parsed, err := strconv.ParseInt(value, 10, target.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a valid %s", value, target.Type())
+		}
+		target.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, target.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a valid %s", value, target.Type())
+		}
+		target.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, target.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a valid %s", value, target.Type())
+		}
+		target.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported type %s", target.Type())
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Condition is a requirement that a request must satisfy, in addition to its
+// HTTP verb and URL pattern, in order to match a route. Conditions are passed
+// to On alongside the handlers of a route, e.g.:
+//
+//	mux.On("POST", "/users", bear.ContentType("application/json"), create)
+//
+// Several routes may share a verb and a pattern as long as they have
+// conditions. They are checked in the order they were added and the first one
+// whose conditions are all satisfied handles the request. A route without
+// conditions for the same verb and pattern (if any) is used when none of the
+// conditional routes match.
+type Condition struct {
+	err      error
+	match    func(*http.Request) bool
+	produces []string // media types declared with Produces
+	status   int      // response status when this condition fails
+}
+
+// Accept returns a Condition that requires the Accept header of a request to
+// allow one of the listed media types, e.g. "application/json", with a q-value
+// greater than 0 (see (*Context).Negotiate). A request without an Accept
+// header accepts any media type. If the path of a request matches but this
+// condition fails, the response is 406 Not Acceptable.
+func Accept(types ...string) Condition {
+	return Condition{
+		match: func(req *http.Request) bool {
+			return negotiate(req.Header, "Accept", types, matchMedia) != empty
+		},
+		status: http.StatusNotAcceptable}
+}
+
+// ContentType returns a Condition that requires the Content-Type header of a
+// request to be one of the listed media types, e.g. "application/json".
+// Parameters such as charset are ignored and a listed type may end in a
+// wildcard subtype, e.g. "text/*". If the path of a request matches but this
+// condition fails, the response is 415 Unsupported Media Type.
+func ContentType(types ...string) Condition {
+	return Condition{
+		match: func(req *http.Request) bool {
+			actual, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+			if err != nil {
+				return false
+			}
+			for _, expected := range types {
+				if mediaMatch(expected, actual) {
+					return true
+				}
+			}
+			return false
+		},
+		status: http.StatusUnsupportedMediaType}
+}
+
+// Header returns a Condition that requires a request to have the header key
+// with the given value. If value is empty, the header only needs to exist.
+func Header(key string, value string) Condition {
+	return Condition{
+		match: func(req *http.Request) bool {
+			values, ok := req.Header[http.CanonicalHeaderKey(key)]
+			if !ok || value == empty {
+				return ok
+			}
+			for _, actual := range values {
+				if actual == value {
+					return true
+				}
+			}
+			return false
+		},
+		status: http.StatusNotFound}
+}
+
+// HeaderRegexp returns a Condition that requires a request to have the header
+// key with a value that matches the regular expression expr. If expr does not
+// compile, the route it is passed to is rejected by On.
+func HeaderRegexp(key string, expr string) Condition {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Condition{err: fmt.Errorf("header %s: %s", key, err)}
+	}
+	return Condition{
+		match: func(req *http.Request) bool {
+			for _, actual := range req.Header[http.CanonicalHeaderKey(key)] {
+				if re.MatchString(actual) {
+					return true
+				}
+			}
+			return false
+		},
+		status: http.StatusNotFound}
+}
+
+// Query returns a Condition that requires a request to have the query string
+// parameter key with the given value. If value is empty, the parameter only
+// needs to exist.
+func Query(key string, value string) Condition {
+	return Condition{
+		match: func(req *http.Request) bool {
+			values, ok := req.URL.Query()[key]
+			if !ok || value == empty {
+				return ok
+			}
+			for _, actual := range values {
+				if actual == value {
+					return true
+				}
+			}
+			return false
+		},
+		status: http.StatusNotFound}
+}
+
+// conditionize separates the conditions from the handlers in the arguments
+// that were passed to On.
+func conditionize(
+	functions []interface{}) ([]Condition, []interface{}, error) {
+	var (
+		conditions []Condition
+		handlers   []interface{}
+	)
+	for _, function := range functions {
+		if condition, ok := function.(Condition); !ok {
+			handlers = append(handlers, function)
+		} else if condition.err != nil {
+			return nil, nil, condition.err
+		} else if condition.match == nil {
+			return nil, nil, fmt.Errorf("empty condition")
+		} else {
+			conditions = append(conditions, condition)
+		}
+	}
+	return conditions, handlers, nil
+}
+
+// mediaMatch returns true if the media range (which may contain wildcards)
+// includes the media type. Both arguments must be free of parameters.
+func mediaMatch(mediaRange string, mediaType string) bool {
+	mediaRange, mediaType = strings.ToLower(mediaRange), strings.ToLower(mediaType)
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, mediaRange[:len(mediaRange)-1])
+	}
+	return false
+}
@@ -4,26 +4,129 @@
 
 package bear
 
-import "net/http"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
 
 // Context is state of each request.
 type Context struct {
 	// Params is a map of string keys with string values that is populated
 	// by the dynamic URL parameters (if any).
 	// Wildcard params are accessed by using an asterisk: Params["*"]
-	Params  map[string]string
-	handler int
-	mux     *Mux
+	Params   map[string]string
+	chain    []HandlerFunc
+	deferred []func()
+	folded   []string
+	handler  int
+	hosted   map[string]string // params of the host pattern (see Mux.Host)
+	matched  route
+	mux      *Mux
 	// Request is the same as the *http.Request that all handlers receive
 	// and is referenced in Context for convenience.
 	Request *http.Request
 	// ResponseWriter is the same as the http.ResponseWriter that all handlers
-	// receive and is referenced in Context for convenience.
+	// receive and is referenced in Context for convenience. Unless it has been
+	// replaced by a middleware, it is a bear.ResponseWriter (see Response).
 	ResponseWriter http.ResponseWriter
-	state          map[string]interface{}
+	path           string
+	response       *response
+	rest           []string
+	routes         *Mux // the Mux (or host sub-router) whose routes serve it
+	scoped         []HandlerFunc
+	state          map[interface{}]interface{}
 	tree           *tree
 }
 
+// canonical returns the request path with the components that matched static
+// tokens regardless of case replaced by the registered tokens.
+func (ctx *Context) canonical() string {
+	original := ctx.mux.requestPath(ctx.Request)
+	canonical := slash + strings.Join(ctx.folded, empty)
+	if !strings.HasSuffix(original, slash) {
+		canonical = strings.TrimSuffix(canonical, slash)
+	}
+	// Keep any prefix that was removed before matching, e.g. a version prefix.
+	if matched := strings.TrimSuffix(ctx.path, slash); matched != empty &&
+		strings.HasSuffix(original, matched) {
+		canonical = strings.TrimSuffix(original, matched) + canonical
+	}
+	return canonical
+}
+
+// Committed returns true if the header of the response has already been
+// written, in which case the status code can no longer be changed.
+func (ctx *Context) Committed() bool {
+	return ctx.response.Written()
+}
+
+// Defer registers a function that will run after the handler chain for the
+// current request (including any After handlers) has finished, even if one of
+// the handlers panics. Deferred functions run in last-in-first-out order, just
+// like Go's defer statement. It returns a pointer to the current Context to
+// allow chaining.
+func (ctx *Context) Defer(function func()) *Context {
+	ctx.deferred = append(ctx.deferred, function)
+	return ctx
+}
+
+// fold records that the path component at index matched the static token key
+// regardless of case. The components are the path components as they were
+// split before matching, i.e. escaped if the Mux routes on raw paths.
+func (ctx *Context) fold(key string, components []string, index int) {
+	if nil == ctx.folded {
+		ctx.folded = append([]string(nil), components...)
+	}
+	if ctx.mux.raw {
+		ctx.folded[index] = url.PathEscape(key[:len(key)-1]) + slash
+	} else {
+		ctx.folded[index] = key
+	}
+}
+
+// unfold forgets that the path components from index on matched static tokens
+// regardless of case, because matching fell back to a wildcard that captures
+// them as they are. The escaped components are those that were passed to fold.
+func (ctx *Context) unfold(escaped []string, index int) {
+	if nil == ctx.folded {
+		return
+	}
+	copy(ctx.folded[index:], escaped[index:])
+	for position, component := range escaped[:index] {
+		if ctx.folded[position] != component {
+			return
+		}
+	}
+	ctx.folded = nil
+}
+
+// Delete removes the state value (if any) of a string key.
+// It returns a pointer to the current Context to allow chaining.
+func (ctx *Context) Delete(key string) *Context {
+	delete(ctx.state, key)
+	return ctx
+}
+
+// Error passes err to the error handler of the Mux (see Mux.OnError), which
+// writes an error response with the status code unless the response has
+// already been committed. Without an error handler, the response is the
+// Problem that describes err (see NewProblem). Error does not stop the
+// handler chain, so handlers should return after calling it.
+func (ctx *Context) Error(status int, err error) {
+	if nil == err {
+		err = errors.New(http.StatusText(status))
+	}
+	if nil != ctx.mux.failure {
+		ctx.mux.failure(ctx, status, err)
+		return
+	}
+	ctx.Problem(NewProblem(status, err))
+}
+
 // Get allows retrieving a state value (interface{})
 func (ctx *Context) Get(key string) interface{} {
 	if nil == ctx.state {
@@ -32,21 +135,77 @@ func (ctx *Context) Get(key string) interface{} {
 	return ctx.state[key]
 }
 
+// Has returns true if a state value exists for a string key, even if the value
+// is nil.
+func (ctx *Context) Has(key string) bool {
+	_, ok := ctx.state[key]
+	return ok
+}
+
+// Keys returns the sorted names of all state keys, i.e. the string keys used
+// with Set and the names of the typed keys (see Key) used with Key.Set.
+func (ctx *Context) Keys() []string {
+	keys := make([]string, 0, len(ctx.state))
+	for key := range ctx.state {
+		switch key := key.(type) {
+		case string:
+			keys = append(keys, key)
+		case fmt.Stringer:
+			keys = append(keys, key.String())
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Next calls the next middleware (if any) that was registered as a handler for
 // a particular request pattern.
 func (ctx *Context) Next() {
 	always := len(ctx.mux.always)
-	handlers := len(ctx.tree.handlers)
+	scoped := len(ctx.scoped)
+	handlers := len(ctx.chain)
 	ctx.handler++
 	if always > 0 && ctx.handler < always {
 		index := ctx.handler
 		ctx.mux.always[index](ctx.ResponseWriter, ctx.Request, ctx)
 		return
 	}
-	if ctx.handler-always < handlers {
+	if scoped > 0 && ctx.handler-always < scoped {
 		index := ctx.handler - always
-		ctx.tree.handlers[index](ctx.ResponseWriter, ctx.Request, ctx)
+		ctx.scoped[index](ctx.ResponseWriter, ctx.Request, ctx)
+		return
+	}
+	if ctx.handler-always-scoped < handlers {
+		index := ctx.handler - always - scoped
+		ctx.chain[index](ctx.ResponseWriter, ctx.Request, ctx)
+	}
+}
+
+// lookup returns the key of the static or enumerated child in children that
+// matches the path component at index (regardless of case, if the Mux matches
+// case-insensitively) and populates the param of an enumerated token. If no
+// such child exists, the component itself is returned.
+func (ctx *Context) lookup(children map[string]*tree, components []string,
+	escaped []string, index int, capacity int) string {
+	component := components[index]
+	if nil != children[component] {
+		return component
+	}
+	key := enumerated + component
+	if nil == children[key] && ctx.mux.casing != CaseSensitive {
+		if folded := fold(children, component); folded != component {
+			ctx.fold(folded, escaped, index)
+			return folded
+		}
+		if key = fold(children, key); nil != children[key] {
+			ctx.fold(key[len(enumerated):], escaped, index)
+		}
 	}
+	if nil != children[key] {
+		ctx.param(children[key].name, component, capacity)
+		return key
+	}
+	return component
 }
 
 func (ctx *Context) param(key string, value string, capacity int) {
@@ -56,13 +215,104 @@ func (ctx *Context) param(key string, value string, capacity int) {
 	ctx.Params[key] = value[:len(value)-1]
 }
 
+// serve runs the handler chain for a request, followed by the After handlers and
+// then the deferred functions, both of which run even if a handler panics.
+func (ctx *Context) serve() {
+	defer ctx.unwind()
+	defer ctx.after()
+	if ctx.mux.recovers {
+		defer ctx.recover()
+	}
+	ctx.Next()
+}
+
+// respond writes a response that no handler chain produces, e.g. an error
+// response or a redirect, followed by the After handlers and the deferred
+// functions, just like serve.
+func (ctx *Context) respond(write func()) {
+	defer ctx.unwind()
+	defer ctx.after()
+	write()
+}
+
+// recover turns a panic of a handler into an error response (see Mux.Recover).
+func (ctx *Context) recover() {
+	if value := recover(); nil != value {
+		if value == http.ErrAbortHandler {
+			panic(value)
+		}
+		ctx.Error(http.StatusInternalServerError, fmt.Errorf("bear: panic: %v", value))
+	}
+}
+
+// after runs the After handlers of the Mux. Calls to Next from within an After
+// handler are no-ops because the handler chain has already been exhausted.
+func (ctx *Context) after() {
+	ctx.handler = len(ctx.mux.always) + len(ctx.scoped) + len(ctx.chain)
+	for _, handler := range ctx.mux.after {
+		handler(ctx.ResponseWriter, ctx.Request, ctx)
+	}
+}
+
+// remainder populates the wildcard param with the path components that remain
+// to be matched. The escaped components are the same components as they were
+// split before matching (i.e. before they were decoded if the Mux routes on
+// raw paths).
+func (ctx *Context) remainder(components []string, escaped []string,
+	capacity int) {
+	ctx.param(asterisk, strings.Join(components, empty), capacity)
+	ctx.rest = escaped
+}
+
+// Response returns the ResponseWriter of the current request, which reports
+// the status code and the size of the response written so far. It records the
+// writes to the http.ResponseWriter that handlers receive, even if a
+// middleware (e.g. one that was converted by Adapt) wrapped that writer. To
+// detect optional interfaces such as http.Flusher, use ctx.ResponseWriter.
+func (ctx *Context) Response() ResponseWriter {
+	return ctx.response
+}
+
+// Segments returns the segments of the remainder of the request path that
+// matched a wildcard pattern, i.e. Params["*"] split at each slash, with each
+// segment decoded individually. If the Mux routes on raw paths (see RawPath),
+// a segment may therefore contain an encoded slash. It returns nil if there is
+// no wildcard param.
+func (ctx *Context) Segments() []string {
+	if nil == ctx.rest {
+		return nil
+	}
+	segments := make([]string, len(ctx.rest))
+	for index, component := range ctx.rest {
+		segments[index] = component[:len(component)-1]
+		if ctx.mux.raw {
+			if segment, err := url.PathUnescape(segments[index]); err == nil {
+				segments[index] = segment
+			}
+		}
+	}
+	return segments
+}
+
 // Set allows setting an arbitrary value (interface{}) to a string key
 // to allow one middleware to pass information to the next.
 // It returns a pointer to the current Context to allow chaining.
 func (ctx *Context) Set(key string, value interface{}) *Context {
+	return ctx.store(key, value)
+}
+
+func (ctx *Context) store(key interface{}, value interface{}) *Context {
 	if nil == ctx.state {
-		ctx.state = make(map[string]interface{})
+		ctx.state = make(map[interface{}]interface{})
 	}
 	ctx.state[key] = value
 	return ctx
 }
+
+// unwind runs the deferred functions in LIFO order. Each function is deferred in
+// turn so that a panic in one of them does not prevent the others from running.
+func (ctx *Context) unwind() {
+	for _, function := range ctx.deferred {
+		defer function()
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Forward dispatches the request internally, i.e. without an HTTP round trip,
+// to the route of mux that matches verb and the path that results from
+// populating pattern with params, e.g.
+//
+//	ctx.Forward("GET", "/users/{id}", map[string]string{"id": ctx.Params["uid"]})
+//
+// The forwarded request is a copy of the request with the new verb and path
+// (and the same query string, headers, and body). It is routed like any other
+// request, so the Always, Use, and After handlers of mux run for it as well,
+// and its response is written to the same http.ResponseWriter. Its Context is
+// new, with its own Params and an empty state (see ForwardShared), although
+// its Params keep the params of the host pattern that the request matched, if
+// any (see Mux.Host). Forward returns once the forwarded request has been
+// handled, after which the handler should usually return as well. A route must
+// not forward to itself.
+//
+// Tokens of pattern are populated with the params of the same name (the
+// wildcard token with the param "*"), and their values are escaped. An optional
+// token without a param is populated with its default value or, if it has
+// none, omitted. Forward returns an error (and writes nothing) if verb is not a
+// valid HTTP verb or if params lack a value for a required token.
+func (ctx *Context) Forward(
+	verb string, pattern string, params map[string]string) error {
+	return ctx.forward(verb, pattern, params, nil)
+}
+
+// ForwardShared is like Forward, except the Context of the forwarded request
+// shares its state with ctx, so values that either of them sets (see Set) are
+// visible to the other.
+func (ctx *Context) ForwardShared(
+	verb string, pattern string, params map[string]string) error {
+	if nil == ctx.state {
+		ctx.state = make(map[interface{}]interface{})
+	}
+	return ctx.forward(verb, pattern, params, ctx.state)
+}
+
+func (ctx *Context) forward(verb string, pattern string,
+	params map[string]string, state map[interface{}]interface{}) error {
+	if tr, _ := ctx.mux.tree(verb); nil == tr {
+		return fmt.Errorf("bear: %s isn't a valid HTTP verb", verb)
+	}
+	escaped, err := expand(pattern, params)
+	if err != nil {
+		return fmt.Errorf("bear: %s %s: %s", verb, pattern, err)
+	}
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil {
+		return fmt.Errorf("bear: %s %s: %s", verb, pattern, err)
+	}
+	req := ctx.Request.Clone(ctx.Request.Context())
+	req.Method = verb
+	req.URL.Path, req.URL.RawPath = unescaped, escaped
+	req.RequestURI = req.URL.RequestURI()
+	ctx.mux.serve(ctx.routes, ctx.ResponseWriter, req, ctx.hosted, state)
+	return nil
+}
+
+// expand returns the escaped path that results from populating the tokens of
+// pattern with params.
+func expand(pattern string, params map[string]string) (string, error) {
+	if pattern == slash || pattern == empty {
+		return slash, nil
+	}
+	slashed := strings.HasSuffix(pattern, slash)
+	_, components, _ := parsePattern(pattern)
+	path := empty
+	for _, component := range components {
+		if component == lasterisk {
+			value, ok := params[asterisk]
+			if !ok {
+				return empty, fmt.Errorf("no value for the wildcard (%s) token",
+					asterisk)
+			}
+			segments := strings.Split(value, slash)
+			for index, segment := range segments {
+				segments[index] = url.PathEscape(segment)
+			}
+			path += slash + strings.Join(segments, slash)
+			continue
+		}
+		match := dyn.FindStringSubmatch(component)
+		if 0 == len(match) {
+			path += slash + strings.TrimSuffix(component, slash)
+			continue
+		}
+		value, ok := params[match[1]]
+		if !ok && strings.HasPrefix(match[3], "=") {
+			value = match[4]
+		} else if !ok && match[3] == "?" {
+			break // optional tokens are last, so the rest are omitted too
+		} else if !ok {
+			return empty, fmt.Errorf("no value for the param %s", match[1])
+		}
+		path += slash + url.PathEscape(value)
+	}
+	if path == empty {
+		return slash, nil
+	}
+	if slashed && !strings.HasSuffix(path, slash) {
+		path += slash
+	}
+	return path, nil
+}
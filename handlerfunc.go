@@ -5,6 +5,7 @@
 package bear
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -13,6 +14,41 @@ import (
 // an extra argument for the *Context of a request.
 type HandlerFunc func(http.ResponseWriter, *http.Request, *Context)
 
+// ServeHTTP calls handler(res, req, ctx), which allows HandlerFunc to satisfy
+// the Handler interface.
+func (handler HandlerFunc) ServeHTTP(
+	res http.ResponseWriter, req *http.Request, ctx *Context) {
+	handler(res, req, ctx)
+}
+
+// Handler is similar to http.Handler, except its ServeHTTP method requires
+// an extra argument for the *Context of a request. Values that implement
+// Handler can be used as middleware, which makes it possible to register
+// stateful handler objects directly.
+type Handler interface {
+	ServeHTTP(http.ResponseWriter, *http.Request, *Context)
+}
+
+// Adapt converts standard net/http middleware, i.e. a function that wraps an
+// http.Handler, into a HandlerFunc that can sit in a bear handler chain. The
+// handlers that follow it receive the same *Context along with whichever
+// http.ResponseWriter and *http.Request the middleware passes on.
+func Adapt(middleware func(http.Handler) http.Handler) HandlerFunc {
+	if middleware == nil {
+		return nil
+	}
+	return HandlerFunc(
+		func(res http.ResponseWriter, req *http.Request, ctx *Context) {
+			next := http.HandlerFunc(
+				func(res http.ResponseWriter, req *http.Request) {
+					ctx.ResponseWriter, ctx.Request = res, req
+					ctx.Next()
+				})
+			defer func() { ctx.ResponseWriter, ctx.Request = res, req }()
+			middleware(next).ServeHTTP(res, req)
+		})
+}
+
 // handlerize takes one of handler formats that Mux accepts.
 // It returns a HandlerFunc, a flag indicating whether the HandlerFunc
 // can be follwed by other handlers, and any error that may have arisen in
@@ -39,6 +75,28 @@ func handlerize(function interface{}) (HandlerFunc, bool, error) {
 				})
 			return handler, followable, nil
 		}
+	case func(*Context) error:
+		handler := function.(func(*Context) error)
+		if handler == nil {
+			return nil, unfollowable, fmt.Errorf("nil middleware")
+		} else {
+			handler := HandlerFunc(
+				func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+					// An error that was already reported (e.g. by Bind) has
+					// committed the response and is not reported again.
+					err := handler(ctx)
+					if err == nil || ctx.Committed() {
+						return
+					}
+					var problem *Problem
+					if errors.As(err, &problem) && 0 != problem.Status {
+						ctx.Error(problem.Status, err)
+					} else {
+						ctx.Error(http.StatusInternalServerError, err)
+					}
+				})
+			return handler, followable, nil
+		}
 	case func(http.ResponseWriter, *http.Request, *Context):
 		handler := function.(func(http.ResponseWriter, *http.Request, *Context))
 		if handler == nil {
@@ -68,10 +126,27 @@ func handlerize(function interface{}) (HandlerFunc, bool, error) {
 				})
 			return handler, unfollowable, nil
 		}
+	case func(http.Handler) http.Handler:
+		handler := Adapt(function.(func(http.Handler) http.Handler))
+		if handler == nil {
+			return nil, unfollowable, fmt.Errorf("nil middleware")
+		} else {
+			return handler, followable, nil
+		}
+	case Handler:
+		return HandlerFunc(function.(Handler).ServeHTTP), followable, nil
+	case http.Handler:
+		handler := function.(http.Handler)
+		return HandlerFunc(
+			func(res http.ResponseWriter, req *http.Request, _ *Context) {
+				handler.ServeHTTP(res, req)
+			}), unfollowable, nil
 	default:
 		err := fmt.Errorf(
-			"handler must match: %s, %s, or %s",
-			"http.HandlerFunc", "bear.HandlerFunc", "func(*Context)")
+			"handler must match: %s, %s, %s, %s, %s, %s, or %s",
+			"http.HandlerFunc", "http.Handler", "bear.HandlerFunc",
+			"bear.Handler", "func(*Context)", "func(*Context) error",
+			"func(http.Handler) http.Handler")
 		return nil, unfollowable, err
 	}
 }
@@ -115,9 +190,19 @@ func handlerizeStrict(functions []interface{}) ([]HandlerFunc, error) {
 			} else {
 				handlers = append(handlers, HandlerFunc(handler))
 			}
+		case func(http.Handler) http.Handler:
+			handler := Adapt(function.(func(http.Handler) http.Handler))
+			if handler == nil {
+				return nil, fmt.Errorf("bear: nil middleware")
+			} else {
+				handlers = append(handlers, handler)
+			}
+		case Handler:
+			handlers = append(handlers, function.(Handler).ServeHTTP)
 		default:
 			return nil, fmt.Errorf(
-				"bear: handler must be a bear.HandlerFunc or match its signature")
+				"bear: handler must be a bear.HandlerFunc, a bear.Handler, " +
+					"net/http middleware, or match the bear.HandlerFunc signature")
 		}
 	}
 	return handlers, nil
@@ -0,0 +1,101 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"net"
+	"strings"
+)
+
+type host struct {
+	dynamics int      // number of dynamic labels, fewer is more specific
+	labels   []string // host pattern labels, with dynamic labels in braces
+	mux      *Mux
+	pattern  string
+}
+
+// hostname strips the port (if any) and the trailing dot of a fully qualified
+// domain name (if any) from s and returns it in lowercase.
+func hostname(s string) string {
+	if name, _, err := net.SplitHostPort(s); err == nil {
+		s = name
+	}
+	return strings.ToLower(strings.TrimSuffix(s, "."))
+}
+
+func (h *host) match(labels []string) (map[string]string, bool) {
+	if len(labels) != len(h.labels) {
+		return nil, false
+	}
+	var params map[string]string
+	for index, label := range h.labels {
+		if match := dyn.FindStringSubmatch(label); 0 < len(match) {
+			if nil == params {
+				params = make(map[string]string, h.dynamics)
+			}
+			params[match[1]] = labels[index]
+		} else if label != labels[index] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// Host returns a sub-router that serves every request whose Host header
+// matches pattern. Host patterns are composed of labels that are separated by
+// "." characters, and each label is either static ("api.example.com") or a
+// dynamically populated parameter ("{tenant}.example.com", where "tenant" will
+// be populated in the *Context.Params of requests served by the sub-router).
+//
+// Matching ignores case as well as the port of the request Host. If several
+// host patterns match a request, the one with the fewest dynamic labels wins.
+// Requests whose Host matches no host pattern fall back to mux itself, which
+// is therefore the default host. Calling Host more than once with the same
+// pattern returns the same sub-router.
+//
+// Like version sub-routers (see Version), host sub-routers only contribute
+// routes, Use handlers, and versions: the Always, After, NotFound, and error
+// handlers (see OnError and OnProblem) of mux apply to every request that a
+// sub-router serves, and so do the policies of mux, e.g. CleanPath,
+// TrailingSlash, and MatchCase. The Use handlers of mux run before those of the
+// sub-router.
+func (mux *Mux) Host(pattern string) *Mux {
+	pattern = hostname(pattern)
+	for _, h := range mux.hosts {
+		if h.pattern == pattern {
+			return h.mux
+		}
+	}
+	h := &host{labels: strings.Split(pattern, "."), mux: New(), pattern: pattern}
+	for _, label := range h.labels {
+		if dyn.MatchString(label) {
+			h.dynamics++
+		}
+	}
+	mux.hosts = append(mux.hosts, h)
+	return h.mux
+}
+
+// host returns the sub-router whose pattern best matches name along with the
+// parsed host params, or a nil sub-router if no host pattern matches.
+func (mux *Mux) host(name string) (*Mux, map[string]string) {
+	var (
+		best   *host
+		labels = strings.Split(hostname(name), ".")
+		params map[string]string
+	)
+	for _, h := range mux.hosts {
+		if nil != best && h.dynamics >= best.dynamics {
+			continue
+		}
+		if matched, ok := h.match(labels); ok {
+			best, params = h, matched
+		}
+	}
+	if nil == best {
+		return nil, nil
+	}
+	return best.mux, params
+}
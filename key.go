@@ -0,0 +1,51 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+// Key is a typed key for the state of a Context. Unlike the string keys used
+// with (*Context).Set and (*Context).Get, two keys created by NewKey never
+// collide, even if they have the same name, and values are retrieved with
+// their type intact, so no type assertion is necessary. Typed keys share the
+// state of a Context with string keys.
+type Key[T any] struct {
+	name string
+}
+
+// NewKey returns a new typed key. The name is only used for display purposes,
+// e.g. in the output of (*Context).Keys.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// Delete removes the state value (if any) of key from ctx.
+// It returns a pointer to ctx to allow chaining.
+func (key *Key[T]) Delete(ctx *Context) *Context {
+	delete(ctx.state, key)
+	return ctx
+}
+
+// Get retrieves the state value of key from ctx. It returns the zero value of
+// T and false if no value was set.
+func (key *Key[T]) Get(ctx *Context) (T, bool) {
+	value, ok := ctx.state[key].(T)
+	return value, ok
+}
+
+// Has returns true if a state value exists for key in ctx.
+func (key *Key[T]) Has(ctx *Context) bool {
+	_, ok := ctx.state[key]
+	return ok
+}
+
+// Set sets the state value of key in ctx to value.
+// It returns a pointer to ctx to allow chaining.
+func (key *Key[T]) Set(ctx *Context, value T) *Context {
+	return ctx.store(key, value)
+}
+
+// String returns the name of key.
+func (key *Key[T]) String() string {
+	return key.name
+}
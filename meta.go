@@ -0,0 +1,71 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+// Meta is metadata about a route, e.g. the scopes it requires or the team that
+// owns it. Meta values are passed to On alongside the handlers of a route, e.g.
+//
+//	mux.On("DELETE", "/users/{id}", bear.Meta{"scope": "admin"}, remove)
+//
+// and the metadata of the route that matches a request is available to all of
+// its handlers, including the Always handlers, via (*Context).Meta. If several
+// Meta values are passed for a route, they are merged and later keys win.
+type Meta map[string]interface{}
+
+// Meta returns the metadata of the route that matched the request, or nil if
+// the route has none (or no route matched). The returned map is shared by all
+// requests to the route, so it must not be modified.
+func (ctx *Context) Meta() Meta {
+	return ctx.matched.meta
+}
+
+// Pattern returns the pattern of the route that matched the request as it was
+// passed to On, apart from normalization (i.e. a leading slash is added and
+// duplicate slashes are removed), e.g. "/users/{id}". It returns an empty
+// string if no route matched. It is the same as ctx.Route().Pattern.
+func (ctx *Context) Pattern() string {
+	return ctx.matched.pattern
+}
+
+// Route describes the route that matched a request (see (*Context).Route).
+type Route struct {
+	Pattern  string // normalized pattern, e.g. "/users/{id}" (see Pattern)
+	Verb     string // HTTP verb, e.g. "GET" (even if it was registered as "*")
+	Wildcard bool   // true if the request fell back to a wildcard pattern
+}
+
+// Route returns the route that matched the request, which allows logs and
+// metrics to aggregate requests by route instead of by path. Like Meta, it is
+// available to all handlers of the request, including the Always handlers. It
+// returns the zero Route if no route matched, e.g. in a NotFound handler.
+func (ctx *Context) Route() Route {
+	return Route{
+		Pattern:  ctx.matched.pattern,
+		Verb:     ctx.matched.verb,
+		Wildcard: nil != ctx.tree && ctx.tree.name == asterisk}
+}
+
+// annotate separates the metadata from the handlers in the arguments that were
+// passed to On and merges it.
+func annotate(functions []interface{}) (Meta, []interface{}) {
+	var (
+		handlers []interface{}
+		meta     Meta
+	)
+	for _, function := range functions {
+		values, ok := function.(Meta)
+		if !ok {
+			handlers = append(handlers, function)
+			continue
+		}
+		if nil == meta {
+			meta = make(Meta, len(values))
+		}
+		for key, value := range values {
+			meta[key] = value
+		}
+	}
+	return meta, handlers
+}
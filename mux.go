@@ -7,6 +7,8 @@ package bear
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
 )
 
@@ -16,9 +18,119 @@ import (
 // argument that allows storing state (using the Get() and Set() methods) and
 // calling the Next() middleware.
 type Mux struct {
-	trees  [8]*tree      // pointers to a tree for each HTTP verb
-	always []HandlerFunc // list of handlers that run for all requests
-	wild   [8]bool       // true if a tree has wildcard (requires back-references)
+	trees    [8]*tree      // pointers to a tree for each HTTP verb
+	always   []HandlerFunc // list of handlers that run for all requests
+	after    []HandlerFunc // list of handlers that run after all requests
+	wild     [8]bool       // true if a tree has wildcard (requires back-references)
+	notFound *tree         // handlers that run when no pattern matches a request
+	hosts    []*host       // sub-routers for specific hosts (see Host)
+	versions []*version    // sub-routers for API versions, newest first
+	scheme   Versioning    // how the API version of a request is determined
+	slash    Slash         // trailing slash policy
+	clean    Clean         // request path cleaning policy
+	casing   Case          // case sensitivity policy for static tokens
+	raw      bool          // true if requests are routed on their escaped paths
+	rest     Remainder     // wildcard remainder policy
+	scopes   *tree         // handlers scoped to path prefixes (see Use)
+	failure  ErrorHandler  // handler of errors (see OnError)
+	problems []ProblemHook // hooks that modify problem responses
+	methods  bool          // true if 405 responses are enabled
+	recovers bool          // true if panics are recovered
+}
+
+// ErrorHandler handles an error that arose while a request was served, e.g. an
+// error that a handler passed to (*Context).Error, by writing a response with
+// the status code (see Mux.OnError).
+type ErrorHandler func(ctx *Context, status int, err error)
+
+// Slash is a policy for trailing slashes (see Mux.TrailingSlash).
+type Slash int
+
+const (
+	// SlashLenient treats a trailing slash as implied, so that a request to
+	// /foo and a request to /foo/ both match the patterns "/foo" and "/foo/".
+	// This is the default policy.
+	SlashLenient Slash = iota
+	// SlashStrict only matches a request to /foo/ with the pattern "/foo/" and
+	// a request to /foo with the pattern "/foo". Other requests are not found.
+	SlashStrict
+	// SlashRedirect permanently redirects requests to the canonical path of the
+	// pattern they match, i.e. with or without a trailing slash, keeping any
+	// query string intact.
+	SlashRedirect
+)
+
+// Remainder is a policy for the remainders of request paths that match
+// wildcard patterns (see Mux.WildcardRemainder).
+type Remainder int
+
+const (
+	// RemainderLenient accepts any remainder. This is the default policy.
+	RemainderLenient Remainder = iota
+	// RemainderStrict rejects remainders that contain empty, ".", or ".."
+	// segments (after decoding), so that requests like /files/../secret or
+	// /files/a//b are not found by the pattern "/files/*".
+	RemainderStrict
+)
+
+// Case is a policy for matching the static tokens of patterns
+// (see Mux.MatchCase).
+type Case int
+
+const (
+	// CaseSensitive only matches static tokens that have the exact case of the
+	// request path. This is the default policy.
+	CaseSensitive Case = iota
+	// CaseInsensitive matches static tokens regardless of case.
+	CaseInsensitive
+	// CaseRedirect matches static tokens regardless of case and
+	// permanently redirects requests whose case differs from the registered
+	// tokens to the path with the registered case.
+	CaseRedirect
+)
+
+// Clean is a policy for request paths that are not in canonical form, i.e.
+// paths that contain empty segments ("//"), "." segments, or ".." segments
+// (see Mux.CleanPath).
+type Clean int
+
+const (
+	// CleanNone routes requests using their paths as they are. This is the
+	// default policy.
+	CleanNone Clean = iota
+	// CleanServe routes requests using their cleaned paths and replaces the
+	// URL path of the *http.Request that handlers receive with the cleaned one.
+	CleanServe
+	// CleanRedirect permanently redirects requests to their cleaned paths,
+	// keeping any query string intact.
+	CleanRedirect
+)
+
+// cleanPath returns the canonical form of the URL path p. Because the path is
+// rooted before it is cleaned, ".." segments can never climb above the root.
+func cleanPath(p string) string {
+	if p == empty {
+		return slash
+	}
+	cleaned := path.Clean(slash + p)
+	if cleaned != slash && slashr == p[len(p)-1] {
+		cleaned += slash // path.Clean removes trailing slashes
+	}
+	return cleaned
+}
+
+// unescape returns the unescaped form of each of the path components (each of
+// which ends in a slash), or nil if any of them is not properly escaped.
+func unescape(components []string) []string {
+	unescaped := make([]string, len(components))
+	for index, component := range components {
+		value, err := url.PathUnescape(component[:len(component)-1])
+		if err != nil {
+			return nil
+		}
+		unescaped[index] = value + slash
+	}
+	return unescaped
 }
 
 func parsePath(s string) (components []string, last int) {
@@ -40,13 +152,36 @@ func parsePath(s string) (components []string, last int) {
 	return components, last
 }
 
+// After adds one or more handlers that will run after the handler chain of
+// every single request has finished, even if a handler in the chain panics.
+// They also run after the responses that mux writes without a handler chain,
+// i.e. redirects (see CleanPath, MatchCase, and TrailingSlash) and error
+// responses to requests that matched no route (e.g. 404 Not Found, 405 Method
+// Not Allowed, or a failed Condition). Multiple calls to After will append the
+// current list of After handlers with the newly added handlers.
+//
+// Handlers must be bear.HandlerFunc functions, functions that match the
+// bear.HandlerFunc signature, or values that implement bear.Handler. Unlike
+// Always handlers, they do not need to call (*Context).Next because each After
+// handler is called in turn.
+func (mux *Mux) After(handlers ...interface{}) error {
+	if functions, err := handlerizeStrict(handlers); err != nil {
+		return err
+	} else {
+		mux.after = append(mux.after, functions...)
+		return err
+	}
+}
+
 // Always adds one or more handlers that will run before every single request.
 // Multiple calls to Always will append the current list of Always handlers with
 // the newly added handlers.
 //
-// Handlers must be either bear.HandlerFunc functions or functions that match
-// the bear.HandlerFunc signature and they should call (*Context).Next to
-// continue the response life cycle.
+// Handlers must be bear.HandlerFunc functions, functions that match the
+// bear.HandlerFunc signature, or values that implement bear.Handler, and they
+// should call (*Context).Next to continue the response life cycle. Standard
+// net/http middleware of the form func(http.Handler) http.Handler is also
+// accepted (see Adapt).
 func (mux *Mux) Always(handlers ...interface{}) error {
 	if functions, err := handlerizeStrict(handlers); err != nil {
 		return err
@@ -56,11 +191,149 @@ func (mux *Mux) Always(handlers ...interface{}) error {
 	}
 }
 
+// Use adds one or more handlers that will run before every request whose path
+// falls under prefix, regardless of HTTP verb. Prefix patterns are written like
+// the patterns passed to On, and a final wildcard token is implied, so "/api"
+// and "/api/*" are equivalent and both apply to "/api" itself as well as to
+// everything beneath it. Multiple calls to Use for the same prefix will append
+// the current list of handlers for that prefix with the newly added handlers.
+//
+// Handlers are accepted in the same formats as Always and they should call
+// (*Context).Next to continue the response life cycle. For each request, the
+// Always handlers run first, then the handlers of every matching prefix (from
+// the shortest prefix to the longest), and finally the route handlers.
+//
+// Prefixes never affect routing: a request matches the same route (e.g.
+// "/{user}/{page}") whether or not a prefix like "/admin" exists, and every
+// prefix that matches its path applies, e.g. both "/admin" and "/{tenant}"
+// apply to /admin/x.
+func (mux *Mux) Use(prefix string, handlers ...interface{}) error {
+	functions, err := handlerizeStrict(handlers)
+	if err != nil {
+		return err
+	}
+	mux.scopes.scope(prefix, functions, &err)
+	return err
+}
+
+// MethodNotAllowed sets whether requests whose path matches a pattern, but
+// only for other HTTP verbs, are answered with 405 Method Not Allowed (along
+// with an Allow header that lists those verbs) instead of being treated as
+// unmatched requests, which is the default. Requests with an unknown HTTP verb
+// are always treated as unmatched requests, and so are requests whose path
+// matches a pattern of their own verb but which are turned away for another
+// reason, e.g. a failed Condition or a stricter TrailingSlash policy.
+func (mux *Mux) MethodNotAllowed(enabled bool) {
+	mux.methods = enabled
+}
+
+// Recover sets whether a panic in a handler (other than http.ErrAbortHandler)
+// is recovered and passed to (*Context).Error with the status 500 Internal
+// Server Error. By default, panics propagate (after the After handlers and the
+// deferred functions of the request have run).
+func (mux *Mux) Recover(enabled bool) {
+	mux.recovers = enabled
+}
+
+// WildcardRemainder sets the policy for the remainders of request paths that
+// match wildcard patterns. See Remainder for the available policies and
+// (*Context).Segments for accessing the segments of a remainder.
+func (mux *Mux) WildcardRemainder(policy Remainder) {
+	mux.rest = policy
+}
+
+// RawPath sets whether requests are routed using their escaped paths (see
+// url.URL.EscapedPath) instead of their decoded paths. When it is enabled, each
+// path segment is decoded individually after the path has been split, so an
+// encoded slash (%2F) can be part of a dynamic parameter, e.g. a request to
+// /files/a%2Fb matches the pattern "/files/{name}" and its name param is "a/b".
+// Redirects issued by the other policies of mux keep the escaped form.
+func (mux *Mux) RawPath(enabled bool) {
+	mux.raw = enabled
+}
+
+// MatchCase sets the policy for matching the static tokens of patterns, e.g. if
+// the policy is CaseInsensitive, a request to /FOO/Bar matches the pattern
+// "/foo/bar". If a request could match several static tokens that differ only
+// by case, the token that sorts first wins. Dynamic parameter and wildcard
+// values are never altered. See Case for the available policies.
+func (mux *Mux) MatchCase(policy Case) {
+	mux.casing = policy
+}
+
+// CleanPath sets the policy for request paths that are not in canonical form,
+// e.g. //foo///bar, /foo/./bar, or /foo/../bar, which are all cleaned to
+// /foo/bar or /bar. With either CleanServe or CleanRedirect, dot segments can
+// never reach the handlers (so a request cannot use them to traverse out of a
+// wildcard pattern, for example). See Clean for the available policies.
+func (mux *Mux) CleanPath(policy Clean) {
+	mux.clean = policy
+}
+
+// TrailingSlash sets the policy for requests whose path differs from the pattern
+// it matches only by a trailing slash, e.g. a request to /foo/ that matches the
+// pattern "/foo". The policy applies to static, dynamic and wildcard patterns
+// alike (a wildcard pattern ending in "*/" expects a trailing slash) and never
+// applies to the root path. See Slash for the available policies.
+func (mux *Mux) TrailingSlash(policy Slash) {
+	mux.slash = policy
+}
+
+// NotFound adds handler(s) that will run whenever a request does not match any
+// pattern, including requests with an unknown HTTP verb. The handler arguments
+// are the same as those accepted by On. NotFound handlers run after the Always
+// handlers and receive the request *Context, whose Params contain any dynamic
+// URL parameters that were parsed before matching failed.
+//
+// If NotFound is never called, unmatched requests are passed to
+// (*Context).Error with the status 404 Not Found, so they are answered by the
+// error handler (see OnError) or with a Problem, and the Always handlers do not
+// run (unlike the After handlers). If MethodNotAllowed is enabled, requests
+// whose path only matches the patterns of other verbs are answered with 405
+// Method Not Allowed instead of running the NotFound handlers.
+func (mux *Mux) NotFound(handlers ...interface{}) error {
+	if nil != mux.notFound {
+		return fmt.Errorf("bear: NotFound handler exists, ignoring")
+	}
+	if functions, err := handlerizeLax("NotFound", "handler", handlers); err != nil {
+		return err
+	} else {
+		mux.notFound = &tree{fallback: route{handlers: functions}}
+		return err
+	}
+}
+
+// OnError sets the handler of the errors that arise while requests are served,
+// i.e. errors that handlers pass to (*Context).Error (or return), including
+// encoding errors of the response helpers (e.g. (*Context).JSON), binding
+// errors, requests that fail the conditions of a route (see Condition),
+// unmatched requests (unless NotFound was called), and recovered panics (see
+// Recover). The handler should check whether the response has already been
+// committed (see (*Context).Committed) before it writes one. If OnError is
+// never called (or handler is nil), errors are answered with a Problem (see
+// (*Context).Problem).
+func (mux *Mux) OnError(handler ErrorHandler) {
+	mux.failure = handler
+}
+
+// OnProblem adds hooks that run before a Problem is written as a response (see
+// (*Context).Problem), which can modify it, e.g. to set its Instance to the ID
+// of the request or to add extension members. Multiple calls to OnProblem
+// will append the current list of hooks with the newly added hooks.
+func (mux *Mux) OnProblem(hooks ...ProblemHook) {
+	mux.problems = append(mux.problems, hooks...)
+}
+
 // On adds HTTP verb handler(s) for a URL pattern. The handler argument(s)
 // should either be http.HandlerFunc or bear.HandlerFunc or conform to the
-// signature of one of those two. NOTE: if http.HandlerFunc (or a function
-// conforming to its signature) is used no other handlers can *follow* it, i.e.
-// it is not middleware.
+// signature of one of those two, or they can be values that implement either
+// http.Handler or bear.Handler. Standard net/http middleware of the form
+// func(http.Handler) http.Handler can be used as middleware (see Adapt), and
+// functions of the form func(*Context) error pass the errors they return to
+// (*Context).Error, unless the response has been committed. NOTE: if
+// http.HandlerFunc (or a function conforming to its signature) or an
+// http.Handler is used no other handlers can *follow* it, i.e. it is not
+// middleware.
 //
 // It returns an error if it fails, but does not panic. Verb strings are
 // uppercase HTTP methods. There is a special verb "*" which can be used to
@@ -70,20 +343,30 @@ func (mux *Mux) Always(handlers ...interface{}) error {
 // pattern will return an error that can safely be ignored (see error example).
 //
 // Pattern strings are composed of tokens that are separated by "/" characters.
-// There are three kinds of tokens:
+// There are five kinds of tokens:
 //
 // 1. static path strings: "/foo/bar/baz/etc"
 //
 // 2. dynamically populated parameters "/foo/{bar}/baz" (where "bar" will be
 // populated in the *Context.Params)
 //
-// 3. wildcard tokens "/foo/bar/*" where * has to be the final token.
+// 3. optional dynamically populated parameters "/foo/{bar?}" or "/foo/{bar=baz}"
+// (where the second form populates "bar" with the default value "baz" if the
+// token is omitted). Optional tokens have to be the final tokens of a pattern,
+// e.g. "/foo/{bar?}/{baz=qux}" matches /foo, /foo/BAR, and /foo/BAR/BAZ.
+//
+// 4. enumerated parameters "/{foo:(bar|baz)}/qux" that only match the listed
+// values and populate "foo" with the value that matched. They are as fast to
+// match as static tokens and may also be optional, e.g. "/{foo:(bar|baz)?}".
+//
+// 5. wildcard tokens "/foo/bar/*" where * has to be the final token.
 // Parsed URL params are available in handlers via the Params map of the
 // *Context argument.
 //
 // Notes:
 //
-// 1. A trailing slash / is always implied, even when not explicit.
+// 1. A trailing slash / is implied, even when not explicit, unless a stricter
+// trailing slash policy is set (see TrailingSlash).
 //
 // 2. Wildcard (*) patterns are only matched if no other (more specific)
 // pattern matches. If multiple wildcard rules match, the most specific takes
@@ -93,6 +376,12 @@ func (mux *Mux) Always(handlers ...interface{}) error {
 // not match the pattern "/foo/bar/*". The only exception to this is the root
 // wildcard pattern "/*" which will match the request path / if no root
 // handler exists.
+//
+// 4. Any Condition arguments (e.g. bear.Header or bear.ContentType) restrict
+// the route to requests that satisfy them, which allows several routes to share
+// a verb and a pattern (see Condition).
+//
+// 5. Any Meta arguments attach metadata to the route (see Meta).
 func (mux *Mux) On(verb string, pattern string, handlers ...interface{}) error {
 	if verb == asterisk {
 		errors := []string{}
@@ -110,129 +399,353 @@ func (mux *Mux) On(verb string, pattern string, handlers ...interface{}) error {
 	if nil == tr {
 		return fmt.Errorf("bear: %s isn't a valid HTTP verb", verb)
 	}
+	meta, handlers := annotate(handlers)
+	conditions, handlers, err := conditionize(handlers)
+	if err != nil {
+		return fmt.Errorf("bear: %s %s: %s", verb, pattern, err)
+	}
 	if functions, err := handlerizeLax(verb, pattern, handlers); err != nil {
 		return err
 	} else {
-		tr.set(verb, pattern, functions, wildcards, &err)
+		r := route{conditions: conditions, handlers: functions, meta: meta}
+		for _, condition := range conditions {
+			r.produces = append(r.produces, condition.produces...)
+		}
+		tr.set(verb, pattern, r, wildcards, &err)
 		return err
 	}
 }
 
 // ServeHTTP allows a Mux instance to conform to the http.Handler interface.
 func (mux *Mux) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	tr, wildcards := mux.tree(req.Method)
+	if 0 < len(mux.hosts) {
+		if sub, params := mux.host(req.Host); nil != sub {
+			mux.serve(sub, res, req, params, nil)
+			return
+		}
+	}
+	mux.serve(mux, res, req, nil, nil)
+}
+
+// serve routes a request using the trees of routes, which is either mux or
+// one of its host sub-routers, with params (which may be nil) as the initial
+// Params of the request Context and state (which may be nil) as its state. The
+// handlers and policies of mux apply either way.
+func (mux *Mux) serve(routes *Mux, res http.ResponseWriter, req *http.Request,
+	params map[string]string, state map[interface{}]interface{}) {
+	tr, wildcards := routes.tree(req.Method)
 	if nil == tr { // if req.Method is not found in HTTP verbs
-		http.NotFound(res, req)
+		mux.miss(mux.context(routes, res, req, params, state))
 		return
 	}
-	// root is a special case because it is the top node in the tree
-	if req.URL.Path == slash || req.URL.Path == empty {
-		if nil != tr.handlers { // root match
-			(&Context{
-				handler:        -1,
-				mux:            mux,
-				tree:           tr,
-				ResponseWriter: res,
-				Request:        req,
-			}).Next()
-			return
-		} else if wild := tr.children[wildcard]; nil != wild {
-			// root level wildcard pattern match
-			(&Context{
-				handler:        -1,
-				mux:            mux,
-				tree:           wild,
-				ResponseWriter: res,
-				Request:        req,
-			}).Next()
-			return
+	path := mux.requestPath(req)
+	if mux.clean != CleanNone {
+		if cleaned := cleanPath(path); cleaned != path {
+			if mux.clean == CleanRedirect {
+				context := mux.context(routes, res, req, params, state)
+				mux.redirect(context, cleaned)
+				return
+			}
+			clone, address := *req, *req.URL
+			mux.locate(&address, cleaned)
+			clone.URL = &address
+			req, path = &clone, cleaned
 		}
-		http.NotFound(res, req)
+	}
+	if 0 < len(routes.versions) &&
+		mux.versioned(routes, res, req, params, state, path) {
 		return
 	}
-	var key string
-	components, last := parsePath(req.URL.Path)
-	capacity := last + 1 // maximum number of params possible for this request
+	context := mux.context(routes, res, req, params, state)
+	mux.dispatch(context, routes.match(context, tr, *wildcards, path))
+}
+
+// context returns a new request Context for a request that routes serves,
+// whose Params are a copy of params (the params of the host pattern, if any)
+// and whose state is state (i.e. it is shared, not copied).
+func (mux *Mux) context(routes *Mux, res http.ResponseWriter, req *http.Request,
+	params map[string]string, state map[interface{}]interface{}) *Context {
+	wrapper, recorder := record(res)
 	context := &Context{
 		handler:        -1,
+		hosted:         params,
 		mux:            mux,
 		Request:        req,
-		ResponseWriter: res}
+		ResponseWriter: wrapper,
+		response:       recorder,
+		routes:         routes,
+		state:          state}
+	for key, value := range params {
+		if nil == context.Params {
+			context.Params = make(map[string]string, len(params))
+		}
+		context.Params[key] = value
+	}
+	return context
+}
+
+// dispatch runs the handlers of node for the request of ctx. If node is nil,
+// i.e. the path of the request matches no pattern of its verb, the response is
+// 405 Method Not Allowed if the path matches patterns of other verbs (and
+// MethodNotAllowed is enabled), otherwise it is left to miss.
+func (mux *Mux) dispatch(ctx *Context, node *tree) {
+	if nil == node {
+		if mux.methods {
+			if allowed := mux.allowed(ctx); 0 < len(allowed) {
+				allow := strings.Join(allowed, ", ")
+				ctx.ResponseWriter.Header().Set("Allow", allow)
+				ctx.respond(func() {
+					ctx.Error(http.StatusMethodNotAllowed, nil)
+				})
+				return
+			}
+		}
+		mux.miss(ctx)
+		return
+	}
+	if mux.rest == RemainderStrict && node.name == asterisk {
+		for _, segment := range ctx.Segments() {
+			if segment == empty || segment == "." || segment == ".." {
+				mux.miss(ctx)
+				return
+			}
+		}
+	}
+	path, redirect := mux.requestPath(ctx.Request), false
+	if nil != ctx.folded && mux.casing == CaseRedirect {
+		path, redirect = ctx.canonical(), true
+	}
+	if mux.slash != SlashLenient &&
+		node.pattern != slash && path != slash && path != empty &&
+		node.slash != strings.HasSuffix(path, slash) {
+		if mux.slash == SlashStrict {
+			mux.miss(ctx)
+			return
+		} else if node.slash {
+			path, redirect = path+slash, true
+		} else {
+			path, redirect = strings.TrimSuffix(path, slash), true
+		}
+	}
+	if redirect {
+		mux.redirect(ctx, path)
+		return
+	}
+	if r, status := node.route(ctx.Request); nil != r.handlers {
+		for key, value := range r.defaults {
+			if nil == ctx.Params {
+				ctx.Params = make(map[string]string, len(r.defaults))
+			}
+			if _, ok := ctx.Params[key]; !ok {
+				ctx.Params[key] = value
+			}
+		}
+		ctx.tree, ctx.chain, ctx.matched = node, r.handlers, r
+		ctx.serve()
+	} else if status == http.StatusNotFound {
+		mux.miss(ctx)
+	} else {
+		ctx.respond(func() { ctx.Error(status, nil) })
+	}
+}
+
+// match returns the node of tr that matches path, or nil if there is no match.
+// While descending the tree, it populates the Params of ctx. It also collects
+// the handlers that were scoped (see Use) to the prefixes of path.
+func (mux *Mux) match(
+	ctx *Context, tr *tree, wildcards bool, path string) *tree {
+	ctx.path = path
+	// root is a special case because it is the top node in the tree
+	if path == slash || path == empty {
+		mux.scope(ctx, nil)
+		if tr.handles() { // root match
+			return tr
+		}
+		return tr.children[wildcard] // root level wildcard pattern match
+	}
+	var key string
+	components, last := parsePath(path)
+	escaped := components
+	if ctx.mux.raw {
+		components = unescape(escaped) // nil if not properly escaped
+	}
+	if mux.scope(ctx, components); nil == components {
+		return nil
+	}
+	capacity := last + 1 // maximum number of params possible for this request
 	current := &tr.children
 	// If no wildcards: simpler, slightly faster logic (this if *always* returns).
-	if !*wildcards {
+	if !wildcards {
 		for index, component := range components {
-			key = component
 			if nil == *current {
-				http.NotFound(res, req)
-				return
-			} else if nil == (*current)[key] {
+				return nil
+			}
+			key = ctx.lookup(*current, components, escaped, index, capacity)
+			if nil == (*current)[key] {
 				if nil == (*current)[dynamic] {
-					http.NotFound(res, req)
-					return
+					return nil
 				} else {
 					key = dynamic
-					context.param((*current)[key].name, component, capacity)
+					ctx.param((*current)[key].name, component, capacity)
 				}
 			}
 			if index == last {
-				if nil == (*current)[key].handlers {
-					http.NotFound(res, req)
-				} else {
-					context.tree = (*current)[key]
-					context.Next()
+				if !(*current)[key].handles() {
+					return nil
 				}
-				return
+				return (*current)[key]
 			}
 			current = &(*current)[key].children
 		}
 	}
 	// If wildcards exist, more involved logic.
-	wild := tr.children[wildcard]
+	root := tr.children[wildcard]
+	wild, depth := root, 0 // depth is the number of components wild consumes
 	for index, component := range components {
-		key = component
+		key = ctx.lookup(*current, components, escaped, index, capacity)
 		if nil == (*current)[key] {
 			if nil == (*current)[dynamic] && nil == (*current)[wildcard] {
-				if nil == wild { // there's no wildcard up the tree
-					http.NotFound(res, req)
-				} else { // wildcard pattern match
-					context.tree = wild
-					context.Next()
+				if nil != wild && wild == root { // the remainder is the path
+					ctx.remainder(components, escaped, capacity)
 				}
-				return
+				if nil != wild {
+					ctx.unfold(escaped, depth)
+				}
+				return wild // nil if there's no wildcard up the tree
 			} else {
 				if nil != (*current)[wildcard] {
 					// i.e. there is a more proximate wildcard
-					wild = (*current)[wildcard]
-					context.param(asterisk,
-						strings.Join(components[index:], empty), capacity)
+					wild, depth = (*current)[wildcard], index
+					ctx.remainder(components[index:], escaped[index:], capacity)
 				}
 				if nil != (*current)[dynamic] {
 					key = dynamic
-					context.param((*current)[key].name, component, capacity)
+					ctx.param((*current)[key].name, component, capacity)
 				} else { // wildcard pattern match
-					context.tree = wild
-					context.Next()
-					return
+					if wild == root {
+						ctx.remainder(components, escaped, capacity)
+					}
+					ctx.unfold(escaped, depth)
+					return wild
 				}
 			}
 		}
 		if index == last {
-			if nil == (*current)[key].handlers {
-				http.NotFound(res, req)
-			} else { // non-wildcard pattern match
-				context.tree = (*current)[key]
-				context.Next()
+			if !(*current)[key].handles() {
+				return nil
 			}
-			return
+			return (*current)[key] // non-wildcard pattern match
 		}
 		current = &(*current)[key].children
 		if nil != (*current)[wildcard] {
-			wild = (*current)[wildcard] // there's a more proximate wildcard
-			context.param(asterisk,
-				strings.Join(components[index:], empty), capacity)
+			// there's a more proximate wildcard
+			wild, depth = (*current)[wildcard], index+1
+			ctx.remainder(components[index+1:], escaped[index+1:], capacity)
+		}
+	}
+	return nil
+}
+
+// scope collects the handlers that were scoped (see Use) to the prefixes of
+// the path whose (decoded) components match splits, from the shortest prefix
+// to the longest: those of the Mux of ctx first, then those of the host
+// sub-router that serves the request (if any), and then, if mux is a version
+// sub-router, those of mux.
+func (mux *Mux) scope(ctx *Context, components []string) {
+	folding := ctx.mux.casing != CaseSensitive
+	scoped := ctx.mux.scopes.collect(components, folding)
+	if ctx.routes != ctx.mux {
+		scoped = append(scoped, ctx.routes.scopes.collect(components, folding)...)
+	}
+	if mux != ctx.mux && mux != ctx.routes {
+		scoped = append(scoped, mux.scopes.collect(components, folding)...)
+	}
+	ctx.scoped = append(ctx.scoped, scoped...)
+}
+
+// miss responds to a request that matched no pattern. If a NotFound handler
+// exists, it runs (after the Always handlers) with the request Context as it
+// was when matching failed, otherwise the response is 404 Not Found (see
+// (*Context).Error).
+func (mux *Mux) miss(ctx *Context) {
+	if nil == mux.notFound {
+		ctx.respond(func() { ctx.Error(http.StatusNotFound, nil) })
+		return
+	}
+	ctx.tree, ctx.chain = mux.notFound, mux.notFound.fallback.handlers
+	ctx.serve()
+}
+
+// allowed returns the other HTTP verbs whose trees (or the trees of the
+// versions that the request may be served by) match the path of the request of
+// ctx, or nil if the request has an unknown verb.
+func (mux *Mux) allowed(ctx *Context) []string {
+	if tr, _ := mux.tree(ctx.Request.Method); nil == tr {
+		return nil
+	}
+	var allowed []string
+	routes := ctx.routes
+	requested, stripped := routes.scheme.parse(ctx.Request, ctx.path)
+	for index, verb := range verbs {
+		if verb == ctx.Request.Method {
+			continue
+		}
+		found := routes.probe(ctx, index, ctx.path)
+		for _, v := range routes.versions {
+			if found {
+				break
+			} else if 0 == requested || v.number <= requested {
+				found = v.mux.probe(ctx, index, stripped)
+			}
+		}
+		if found {
+			allowed = append(allowed, verb)
 		}
 	}
+	return allowed
+}
+
+// probe returns true if the tree of mux at index has a route that matches path
+// for the request of ctx.
+func (mux *Mux) probe(ctx *Context, index int, path string) bool {
+	probe := &Context{mux: ctx.mux, Request: ctx.Request, routes: ctx.routes}
+	node := mux.match(probe, mux.trees[index], mux.wild[index], path)
+	return nil != node && node.handles()
+}
+
+// locate sets the path of address to p, which is escaped if mux routes on raw
+// paths (see RawPath).
+func (mux *Mux) locate(address *url.URL, p string) {
+	address.Path, address.RawPath = p, empty
+	if mux.raw {
+		if unescaped, err := url.PathUnescape(p); err == nil {
+			address.Path, address.RawPath = unescaped, p
+		}
+	}
+}
+
+// requestPath returns the path that mux uses to route req.
+func (mux *Mux) requestPath(req *http.Request) string {
+	if mux.raw {
+		return req.URL.EscapedPath()
+	}
+	return req.URL.Path
+}
+
+// redirect permanently redirects a request to path, keeping its query string.
+// GET and HEAD requests are redirected with 301 Moved Permanently, while other
+// requests are redirected with 308 Permanent Redirect to preserve their method.
+func (mux *Mux) redirect(ctx *Context, path string) {
+	status := http.StatusPermanentRedirect
+	if method := ctx.Request.Method; method == "GET" || method == "HEAD" {
+		status = http.StatusMovedPermanently
+	}
+	// A leading double slash would make the location a protocol-relative URL.
+	location := &url.URL{RawQuery: ctx.Request.URL.RawQuery}
+	mux.locate(location, slash+strings.TrimLeft(path, slash))
+	ctx.respond(func() {
+		http.Redirect(ctx.ResponseWriter, ctx.Request, location.String(), status)
+	})
 }
 
 func (mux *Mux) tree(name string) (*tree, *bool) {
@@ -262,6 +775,7 @@ func (mux *Mux) tree(name string) (*tree, *bool) {
 func New() *Mux {
 	mux := new(Mux)
 	mux.trees = [8]*tree{{}, {}, {}, {}, {}, {}, {}, {}}
+	mux.scopes = &tree{}
 	mux.wild = [8]bool{false, false, false, false, false, false, false, false}
 	return mux
 
@@ -0,0 +1,182 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// preference is an element of an Accept* header, e.g. "text/html;q=0.8".
+type preference struct {
+	value string
+	q     float64
+}
+
+// Negotiate returns the offer that the Accept header of the request prefers,
+// e.g. ctx.Negotiate("application/json", "text/html") returns "text/html" for
+// a request that accepts "text/html, application/json;q=0.9". The preference
+// for an offer is the q-value of the most specific media range that includes
+// it, so "text/*;q=0.5, text/plain" prefers text/plain to text/css. Ties are
+// broken by the order of the offers, and if the request has no Accept header,
+// the first offer is returned. If the request accepts none of the offers, the
+// empty string is returned.
+//
+// If no offers are passed, the media types that the route declared with
+// Produces are negotiated instead.
+func (ctx *Context) Negotiate(offers ...string) string {
+	if 0 == len(offers) {
+		offers = ctx.matched.produces
+	}
+	return negotiate(ctx.Request.Header, "Accept", offers, matchMedia)
+}
+
+// NegotiateCharset returns the offer (e.g. "utf-8") that the Accept-Charset
+// header of the request prefers, in the same manner as Negotiate.
+func (ctx *Context) NegotiateCharset(offers ...string) string {
+	return negotiate(ctx.Request.Header, "Accept-Charset", offers, matchToken)
+}
+
+// NegotiateEncoding returns the offer (e.g. "gzip") that the Accept-Encoding
+// header of the request prefers, in the same manner as Negotiate. The offer
+// "identity" (i.e. no encoding) is acceptable unless the header explicitly
+// excludes it, e.g. with "identity;q=0" or "*;q=0".
+func (ctx *Context) NegotiateEncoding(offers ...string) string {
+	return negotiate(ctx.Request.Header, "Accept-Encoding", offers, matchToken)
+}
+
+// NegotiateLanguage returns the offer (e.g. "en-US") that the Accept-Language
+// header of the request prefers, in the same manner as Negotiate. A language
+// range matches the language tags that it is a prefix of, e.g. "en" matches
+// "en-US", and a longer range is more specific.
+func (ctx *Context) NegotiateLanguage(offers ...string) string {
+	return negotiate(ctx.Request.Header, "Accept-Language", offers, matchLanguage)
+}
+
+// Produces returns a Condition that declares the media types that a route can
+// respond with, e.g. "application/json". It requires the Accept header of a
+// request to allow one of them (a request without an Accept header accepts
+// any media type) and makes them the default offers of (*Context).Negotiate
+// for the handlers of the route. If the path of a request matches but this
+// condition fails, the response is 406 Not Acceptable.
+func Produces(types ...string) Condition {
+	condition := Accept(types...)
+	condition.produces = types
+	return condition
+}
+
+// negotiate returns the offer that the header key prefers, as determined by
+// match, which returns the specificity with which a header value includes an
+// offer (or 0 if it does not include it).
+func negotiate(header http.Header, key string, offers []string,
+	match func(string, string) int) string {
+	values, ok := header[key]
+	if !ok {
+		if 0 < len(offers) {
+			return offers[0]
+		}
+		return empty
+	}
+	preferences := parsePreferences(strings.Join(values, ","), key == "Accept")
+	best, chosen := 0.0, empty
+	for _, offer := range offers {
+		q, specificity := 0.0, 0
+		for _, preference := range preferences {
+			if score := match(preference.value, offer); score > specificity {
+				q, specificity = preference.q, score
+			}
+		}
+		if 0 == specificity && key == "Accept-Encoding" &&
+			strings.EqualFold(offer, "identity") {
+			q = 1 // identity is acceptable unless it is explicitly excluded
+		}
+		if q > best {
+			best, chosen = q, offer
+		}
+	}
+	return chosen
+}
+
+// parsePreferences returns the elements of an Accept* header. Media ranges
+// (if media is true) are stripped of their parameters other than q.
+func parsePreferences(header string, media bool) []preference {
+	var preferences []preference
+	for _, part := range strings.Split(header, ",") {
+		var (
+			value  string
+			params map[string]string
+		)
+		if part = strings.TrimSpace(part); part == empty {
+			continue
+		}
+		if media {
+			var err error
+			if value, params, err = mime.ParseMediaType(part); err != nil {
+				continue
+			}
+		} else {
+			fields := strings.Split(part, ";")
+			value, params = strings.ToLower(strings.TrimSpace(fields[0])), map[string]string{}
+			for _, field := range fields[1:] {
+				if key, param, ok := strings.Cut(strings.TrimSpace(field), "="); ok {
+					params[strings.ToLower(key)] = param
+				}
+			}
+		}
+		q := 1.0
+		if param, ok := params["q"]; ok {
+			parsed, err := strconv.ParseFloat(param, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			q = parsed
+		}
+		preferences = append(preferences, preference{value, q})
+	}
+	return preferences
+}
+
+// matchLanguage returns the specificity with which the language range includes
+// the language tag offer.
+func matchLanguage(languageRange string, offer string) int {
+	offer = strings.ToLower(offer)
+	switch {
+	case languageRange == asterisk:
+		return 1
+	case languageRange == offer || strings.HasPrefix(offer, languageRange+"-"):
+		return 1 + len(languageRange)
+	}
+	return 0
+}
+
+// matchMedia returns the specificity with which the media range includes the
+// media type offer.
+func matchMedia(mediaRange string, offer string) int {
+	mediaType, _, err := mime.ParseMediaType(offer)
+	if err != nil || !mediaMatch(mediaRange, mediaType) {
+		return 0
+	}
+	switch {
+	case mediaRange == "*/*":
+		return 1
+	case strings.HasSuffix(mediaRange, "/*"):
+		return 2
+	}
+	return 3
+}
+
+// matchToken returns the specificity with which the value of an
+// Accept-Charset or an Accept-Encoding header includes offer.
+func matchToken(value string, offer string) int {
+	switch {
+	case value == asterisk:
+		return 1
+	case strings.EqualFold(value, offer):
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Problem is a problem details object (RFC 7807) that describes an error
+// response in a machine-readable format. Unless an error handler was set with
+// Mux.OnError, the errors passed to (*Context).Error are answered with a
+// Problem. A Problem is also an error, so a handler can pass (or return) one
+// to set every member of the response.
+type Problem struct {
+	Type       string                 `json:"type,omitempty"`
+	Title      string                 `json:"title,omitempty"`
+	Status     int                    `json:"status,omitempty"`
+	Detail     string                 `json:"detail,omitempty"`
+	Instance   string                 `json:"instance,omitempty"`
+	Extensions map[string]interface{} `json:"-"` // additional members
+}
+
+// ProblemHook modifies a Problem before it is written as the response to the
+// request of ctx (see Mux.OnProblem).
+type ProblemHook func(ctx *Context, problem *Problem)
+
+// Error returns the title and the detail of a Problem.
+func (problem *Problem) Error() string {
+	if problem.Detail == empty {
+		return problem.Title
+	}
+	return problem.Title + ": " + problem.Detail
+}
+
+// MarshalJSON encodes a Problem as a JSON object whose members are the members
+// of the Problem and its extension members. Extension members cannot replace
+// the standard ones.
+func (problem Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(problem.Extensions)+5)
+	for key, value := range problem.Extensions {
+		members[key] = value
+	}
+	type standard Problem // has no MarshalJSON method
+	data, err := json.Marshal(standard(problem))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+// NewProblem returns the Problem that describes err, which a response with the
+// status code reports. If err is (or wraps) a Problem, a copy of it is returned
+// with the status as its default Status. Otherwise, the Problem has the status
+// text as its title and, for client errors (4xx), the text of err as its
+// detail. The text of a server error is never disclosed. The fields of
+// FieldErrors are listed in the extension member "errors".
+func NewProblem(status int, err error) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		clone := *problem
+		if 0 == clone.Status {
+			clone.Status = status
+		}
+		if clone.Title == empty {
+			clone.Title = http.StatusText(clone.Status)
+		}
+		return &clone
+	}
+	problem = &Problem{Title: http.StatusText(status), Status: status}
+	if status >= 500 || nil == err || err.Error() == problem.Title {
+		return problem
+	}
+	problem.Detail = err.Error()
+	var errs FieldErrors
+	if errors.As(err, &errs) {
+		problem.Extensions = map[string]interface{}{"errors": errs}
+	}
+	return problem
+}
+
+// Problem writes problem as the response, after the hooks of the Mux (see
+// Mux.OnProblem) have had the chance to modify it. The response is encoded as
+// application/problem+json if the Accept header of the request explicitly
+// allows JSON, and as text/plain otherwise. Nothing is written if the response
+// has already been committed.
+func (ctx *Context) Problem(problem *Problem) {
+	for _, hook := range ctx.mux.problems {
+		hook(ctx, problem)
+	}
+	if ctx.Committed() {
+		return
+	}
+	status := problem.Status
+	if 0 == status {
+		status = http.StatusInternalServerError
+	}
+	if !acceptsJSON(ctx.Request.Header.Get("Accept")) {
+		http.Error(ctx.ResponseWriter, problem.Error(), status)
+		return
+	}
+	data, err := json.Marshal(problem)
+	if err != nil { // e.g. an extension member that cannot be encoded
+		http.Error(ctx.ResponseWriter, problem.Error(), status)
+		return
+	}
+	ctx.Blob(status, "application/problem+json", data)
+}
+
+// acceptsJSON returns true if the Accept header names a JSON media type (as
+// opposed to allowing one by way of a wildcard).
+func acceptsJSON(header string) bool {
+	for _, preference := range parsePreferences(header, true) {
+		if 0 < preference.q && (preference.value == "application/json" ||
+			strings.HasSuffix(preference.value, "+json")) {
+			return true
+		}
+	}
+	return false
+}
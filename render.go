@@ -0,0 +1,71 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Blob writes a response with the status code, the content type, and data as
+// its body.
+func (ctx *Context) Blob(status int, contentType string, data []byte) {
+	if contentType != empty {
+		ctx.ResponseWriter.Header().Set("Content-Type", contentType)
+	}
+	ctx.ResponseWriter.WriteHeader(status)
+	if _, err := ctx.ResponseWriter.Write(data); err != nil {
+		ctx.Error(http.StatusInternalServerError, err)
+	}
+}
+
+// HTML writes a response with the status code and html as its body.
+func (ctx *Context) HTML(status int, html string) {
+	ctx.Blob(status, "text/html; charset=utf-8", []byte(html))
+}
+
+// JSON writes a response with the status code and the JSON encoding of value
+// as its body. If value cannot be encoded, nothing is written and the error is
+// passed to (*Context).Error with the status 500 Internal Server Error.
+func (ctx *Context) JSON(status int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		ctx.Error(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.Blob(status, "application/json; charset=utf-8", data)
+}
+
+// NoContent writes a response with the status code and no body, e.g. a 204 No
+// Content or a 304 Not Modified response.
+func (ctx *Context) NoContent(status int) {
+	ctx.ResponseWriter.WriteHeader(status)
+}
+
+// Redirect redirects the request to location with the status code, which
+// should be in the 3xx range (see http.Redirect).
+func (ctx *Context) Redirect(status int, location string) {
+	http.Redirect(ctx.ResponseWriter, ctx.Request, location, status)
+}
+
+// Stream writes a response with the status code and the content type, and then
+// copies the body of the response from reader until EOF. If copying fails, the
+// error is passed to (*Context).Error with the status 500 Internal Server
+// Error, although by then the response has already been committed.
+func (ctx *Context) Stream(status int, contentType string, reader io.Reader) {
+	if contentType != empty {
+		ctx.ResponseWriter.Header().Set("Content-Type", contentType)
+	}
+	ctx.ResponseWriter.WriteHeader(status)
+	if _, err := io.Copy(ctx.ResponseWriter, reader); err != nil {
+		ctx.Error(http.StatusInternalServerError, err)
+	}
+}
+
+// Text writes a response with the status code and text as its body.
+func (ctx *Context) Text(status int, text string) {
+	ctx.Blob(status, "text/plain; charset=utf-8", []byte(text))
+}
@@ -0,0 +1,190 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter is the http.ResponseWriter that Mux passes to handlers. In
+// addition to writing the response, it records what has been written, which
+// allows middleware to inspect the outcome of the handlers that follow it.
+// It implements io.ReaderFrom and can be unwrapped by an
+// http.ResponseController. The http.ResponseWriter that handlers receive also
+// implements http.Flusher, http.Hijacker, and http.Pusher if (and only if)
+// the underlying http.ResponseWriter does, so handlers can still detect those
+// features with type assertions.
+type ResponseWriter interface {
+	http.ResponseWriter
+	io.ReaderFrom
+	// BytesWritten returns the number of response body bytes written so far.
+	BytesWritten() int64
+	// Status returns the status code of the response, or 0 if the header has
+	// not been written yet.
+	Status() int
+	// TimeToFirstByte returns the time that elapsed between the start of the
+	// response and the moment its header was written, or 0 if the header has
+	// not been written yet.
+	TimeToFirstByte() time.Duration
+	// Written returns true if the header of the response has been written.
+	Written() bool
+}
+
+type response struct {
+	http.ResponseWriter
+	bytes  int64
+	first  time.Duration
+	start  time.Time
+	status int
+}
+
+// flusher, hijacker, and pusher add the optional interfaces of the underlying
+// http.ResponseWriter to a response.
+type (
+	flusher  struct{ *response }
+	hijacker struct{ *response }
+	pusher   struct{ *response }
+)
+
+// recorded is implemented by every wrapper that record returns.
+type recorded interface {
+	recorder() *response
+}
+
+// record wraps res in a recorder, unless it already is one. It returns the
+// recorder along with the wrapper that handlers receive, which implements the
+// same optional interfaces as res.
+func record(res http.ResponseWriter) (http.ResponseWriter, *response) {
+	if wrapper, ok := res.(recorded); ok {
+		return res, wrapper.recorder()
+	}
+	recorder := &response{ResponseWriter: res, start: time.Now()}
+	_, flushes := res.(http.Flusher)
+	_, hijacks := res.(http.Hijacker)
+	_, pushes := res.(http.Pusher)
+	f, h, p := flusher{recorder}, hijacker{recorder}, pusher{recorder}
+	switch {
+	case flushes && hijacks && pushes:
+		return struct {
+			*response
+			flusher
+			hijacker
+			pusher
+		}{recorder, f, h, p}, recorder
+	case flushes && hijacks:
+		return struct {
+			*response
+			flusher
+			hijacker
+		}{recorder, f, h}, recorder
+	case flushes && pushes:
+		return struct {
+			*response
+			flusher
+			pusher
+		}{recorder, f, p}, recorder
+	case hijacks && pushes:
+		return struct {
+			*response
+			hijacker
+			pusher
+		}{recorder, h, p}, recorder
+	case flushes:
+		return struct {
+			*response
+			flusher
+		}{recorder, f}, recorder
+	case hijacks:
+		return struct {
+			*response
+			hijacker
+		}{recorder, h}, recorder
+	case pushes:
+		return struct {
+			*response
+			pusher
+		}{recorder, p}, recorder
+	}
+	return recorder, recorder
+}
+
+func (res *response) BytesWritten() int64 {
+	return res.bytes
+}
+
+func (res flusher) Flush() {
+	res.commit(http.StatusOK)
+	res.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (res hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return res.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (res pusher) Push(target string, opts *http.PushOptions) error {
+	return res.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (res *response) ReadFrom(src io.Reader) (int64, error) {
+	if from, ok := res.ResponseWriter.(io.ReaderFrom); ok {
+		res.commit(http.StatusOK)
+		n, err := from.ReadFrom(src)
+		res.bytes += n
+		return n, err
+	}
+	// Hide ReadFrom from io.Copy, which would otherwise call it again.
+	return io.Copy(struct{ io.Writer }{res}, src)
+}
+
+func (res *response) recorder() *response {
+	return res
+}
+
+func (res *response) Status() int {
+	return res.status
+}
+
+func (res *response) TimeToFirstByte() time.Duration {
+	return res.first
+}
+
+func (res *response) Unwrap() http.ResponseWriter {
+	return res.ResponseWriter
+}
+
+func (res *response) Write(data []byte) (int, error) {
+	res.commit(http.StatusOK)
+	n, err := res.ResponseWriter.Write(data)
+	res.bytes += int64(n)
+	return n, err
+}
+
+func (res *response) WriteHeader(status int) {
+	// Informational headers (except for 101 Switching Protocols) can be
+	// followed by another header, so they are not recorded.
+	if status < http.StatusOK && status != http.StatusSwitchingProtocols {
+		res.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !res.Written() {
+		res.commit(status)
+	}
+	res.ResponseWriter.WriteHeader(status)
+}
+
+func (res *response) Written() bool {
+	return 0 != res.status
+}
+
+// commit records that the header of the response has been written.
+func (res *response) commit(status int) {
+	if !res.Written() {
+		res.status, res.first = status, time.Since(res.start)
+	}
+}
@@ -6,14 +6,103 @@ package bear
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 )
 
+type route struct {
+	conditions []Condition
+	defaults   map[string]string // values of omitted optional params
+	handlers   []HandlerFunc
+	meta       Meta
+	pattern    string   // the pattern as it was passed to On (normalized)
+	produces   []string // media types declared with Produces
+	verb       string
+}
+
 type tree struct {
 	children map[string]*tree
-	handlers []HandlerFunc
+	fallback route // unconditional route, used if no conditional route matches
 	name     string
 	pattern  string
+	routes   []route       // conditional routes, checked first
+	scoped   []HandlerFunc // handlers that run for all paths under this prefix
+	slash    bool          // true if the pattern has an explicit trailing slash
+}
+
+// assign adds a route to a node.
+func (tr *tree) assign(verb string, pattern string, r route, err *error) {
+	if 0 < len(r.conditions) {
+		tr.pattern = pattern
+		tr.routes = append(tr.routes, r)
+		return
+	}
+	if nil != tr.fallback.handlers {
+		*err = fmt.Errorf("bear: %s %s exists, ignoring", verb, pattern)
+		return
+	}
+	tr.pattern = pattern
+	tr.fallback = r
+}
+
+// collect returns the scoped handlers (see Mux.Use) of a prefix tree whose
+// prefixes match the path components, ordered from the shortest prefix to the
+// longest. Because prefixes do not compete with each other the way routes do,
+// every matching branch is followed, i.e. static, enumerated and dynamic keys
+// alike (in that order at each depth). If folding is true, static and
+// enumerated keys match regardless of case.
+func (tr *tree) collect(components []string, folding bool) []HandlerFunc {
+	if nil == tr.scoped && 0 == len(tr.children) { // Use was never called
+		return nil
+	}
+	handlers := append([]HandlerFunc(nil), tr.scoped...)
+	level := []*tree{tr}
+	for _, component := range components {
+		var next []*tree
+		for _, node := range level {
+			for _, key := range []string{component, enumerated + component} {
+				if nil != node.children[key] {
+					next = append(next, node.children[key])
+				} else if folding {
+					if folded := fold(node.children, key); nil != node.children[folded] {
+						next = append(next, node.children[folded])
+					}
+				}
+			}
+			if nil != node.children[dynamic] {
+				next = append(next, node.children[dynamic])
+			}
+		}
+		if 0 == len(next) {
+			break
+		}
+		for _, node := range next {
+			handlers = append(handlers, node.scoped...)
+		}
+		level = next
+	}
+	return handlers
+}
+
+// fold returns the key of the static child in children that matches component
+// regardless of case, or component itself if there is no such key. If several
+// keys match, the one that sorts first wins.
+func fold(children map[string]*tree, component string) string {
+	folded := empty
+	for key := range children {
+		if strings.EqualFold(key, component) && (folded == empty || key < folded) {
+			folded = key
+		}
+	}
+	if folded == empty {
+		return component
+	}
+	return folded
+}
+
+// handles returns true if a node has any handlers, conditional or not.
+func (tr *tree) handles() bool {
+	return nil != tr.fallback.handlers || 0 < len(tr.routes)
 }
 
 func parsePattern(s string) (pattern string, components []string, last int) {
@@ -30,51 +119,179 @@ func parsePattern(s string) (pattern string, components []string, last int) {
 	return pattern, components, last
 }
 
-func (tr *tree) set(verb string, pattern string, handlers []HandlerFunc,
+// optionals returns the default values of the optional tokens in components.
+func optionals(components []string) map[string]string {
+	var defaults map[string]string
+	for _, component := range components {
+		if match := dyn.FindStringSubmatch(component); 0 < len(match) &&
+			strings.HasPrefix(match[3], "=") {
+			if nil == defaults {
+				defaults = make(map[string]string)
+			}
+			defaults[match[1]] = match[4]
+		}
+	}
+	return defaults
+}
+
+// route returns the first conditional route of a node whose conditions are all
+// satisfied by req, or the unconditional route if there is no such route. If
+// there is no suitable route, it returns a route without handlers and the
+// status of the first failing condition that is more specific than 404 Not
+// Found.
+func (tr *tree) route(req *http.Request) (route, int) {
+	status := http.StatusNotFound
+	for _, candidate := range tr.routes {
+		failed := 0
+		for _, condition := range candidate.conditions {
+			if !condition.match(req) {
+				failed = condition.status
+				break
+			}
+		}
+		if 0 == failed {
+			return candidate, http.StatusOK
+		} else if status == http.StatusNotFound {
+			status = failed
+		}
+	}
+	if nil != tr.fallback.handlers {
+		return tr.fallback, http.StatusOK
+	}
+	return route{}, status
+}
+
+func (tr *tree) set(verb string, pattern string, r route,
 	wildcards *bool, err *error) {
+	r.verb = verb
 	if pattern == slash || pattern == empty {
-		if nil != tr.handlers {
-			*err = fmt.Errorf("bear: %s %s exists, ignoring", verb, pattern)
-			return
-		}
-		tr.pattern = slash
-		tr.handlers = handlers
+		r.pattern = slash
+		tr.assign(verb, slash, r, err)
 		return
 	}
-	if nil == tr.children {
-		tr.children = make(map[string]*tree)
-	}
-	current := &tr.children
+	slashed := strings.HasSuffix(pattern, slash)
 	pattern, components, last := parsePattern(pattern)
+	if r.pattern = pattern; !slashed {
+		r.pattern = strings.TrimSuffix(pattern, slash)
+	}
+	keys, names := tokenize(components)
+	// Optional tokens are only allowed at the end of a pattern.
+	optional := last + 1
+	for optional > 0 {
+		match := dyn.FindStringSubmatch(components[optional-1])
+		if 0 == len(match) || match[3] == empty {
+			break
+		}
+		optional--
+	}
 	for index, component := range components {
-		var (
-			match []string = dyn.FindStringSubmatch(component)
-			key   string   = component
-			name  string
-		)
-		if 0 < len(match) {
-			key, name = dynamic, match[1]
-		} else if key == lasterisk {
-			key, name = wildcard, asterisk
+		if match := dyn.FindStringSubmatch(component); index < optional &&
+			0 < len(match) && match[3] != empty {
+			*err = fmt.Errorf("bear: %s %s optional token (%s) must be last",
+				verb, pattern, strings.TrimSuffix(component, slash))
+			return
+		} else if component == lasterisk && index != last {
+			*err = fmt.Errorf("bear: %s %s wildcard (%s) token must be last",
+				verb, pattern, asterisk)
+			return
+		} else if component == lasterisk {
 			*wildcards = true
 		}
-		if nil == (*current)[key] {
-			(*current)[key] = &tree{
-				children: make(map[string]*tree), name: name}
+	}
+	// An enumerated token adds a branch to the tree for each of its values, so
+	// insert descends every branch, adding nodes as necessary.
+	var insert func(current *tree, index int)
+	insert = func(current *tree, index int) {
+		if index == len(components) {
+			if current.assign(verb, pattern, r, err); nil == *err {
+				current.slash = slashed
+			}
+			return
 		}
-		if index == last {
-			if nil != (*current)[key].handlers {
-				*err = fmt.Errorf("bear: %s %s exists, ignoring", verb, pattern)
+		// A pattern that ends before an optional token is a route as well.
+		if index >= optional {
+			prefix := r
+			prefix.defaults = optionals(components[index:])
+			current.assign(verb, pattern, prefix, err)
+			if nil != *err {
 				return
 			}
-			(*current)[key].pattern = pattern
-			(*current)[key].handlers = handlers
+			current.slash = slashed
+		}
+		if nil == current.children {
+			current.children = make(map[string]*tree)
+		}
+		for _, key := range keys[index] {
+			if nil == current.children[key] {
+				current.children[key] = &tree{
+					children: make(map[string]*tree), name: names[index]}
+			}
+			if insert(current.children[key], index+1); nil != *err {
+				return
+			}
+		}
+	}
+	insert(tr, 0)
+}
+
+func (tr *tree) scope(prefix string, handlers []HandlerFunc, err *error) {
+	if prefix == slash || prefix == empty {
+		tr.scoped = append(tr.scoped, handlers...)
+		return
+	}
+	prefix, components, last := parsePattern(prefix)
+	if last >= 0 && components[last] == lasterisk {
+		components = components[:last]
+	}
+	for _, component := range components {
+		if component == lasterisk {
+			*err = fmt.Errorf("bear: %s wildcard (%s) token must be last",
+				prefix, asterisk)
 			return
-		} else if key == wildcard {
-			*err = fmt.Errorf("bear: %s %s wildcard (%s) token must be last",
-				verb, pattern, asterisk)
+		}
+	}
+	keys, names := tokenize(components)
+	var insert func(current *tree, index int)
+	insert = func(current *tree, index int) {
+		if index == len(components) {
+			current.scoped = append(current.scoped, handlers...)
 			return
 		}
-		current = &(*current)[key].children
+		if nil == current.children {
+			current.children = make(map[string]*tree)
+		}
+		for _, key := range keys[index] {
+			if nil == current.children[key] {
+				current.children[key] = &tree{
+					children: make(map[string]*tree), name: names[index]}
+			}
+			insert(current.children[key], index+1)
+		}
+	}
+	insert(tr, 0)
+}
+
+// tokenize returns the tree keys that each pattern component stands for, along
+// with the name of the param (if any) that each component populates. Static
+// and dynamic components stand for a single key, while enumerated components
+// stand for one key per enumerated value.
+func tokenize(components []string) (keys [][]string, names []string) {
+	keys, names = make([][]string, len(components)), make([]string, len(components))
+	for index, component := range components {
+		match := dyn.FindStringSubmatch(component)
+		switch {
+		case 0 < len(match) && match[2] != empty:
+			for _, value := range strings.Split(match[2], "|") {
+				keys[index] = append(keys[index], enumerated+value+slash)
+			}
+			names[index] = match[1]
+		case 0 < len(match):
+			keys[index], names[index] = []string{dynamic}, match[1]
+		case component == lasterisk:
+			keys[index], names[index] = []string{wildcard}, asterisk
+		default:
+			keys[index] = []string{component}
+		}
 	}
+	return keys, names
 }
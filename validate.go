@@ -0,0 +1,256 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// patterns caches the regular expressions of validation rules.
+var patterns sync.Map
+
+// Validate checks the fields of the struct that value is (or points to) against
+// the rules in their validate tags, which are separated by commas:
+//
+//	required     the field must not be empty (or nil, or zero)
+//	min=N        a number must be at least N, a string, slice, or map must
+//	             have a length of at least N
+//	max=N        like min, but N is the maximum
+//	len=N        a string, slice, or map must have a length of exactly N
+//	enum=a|b|c   the field must be one of the listed values
+//	regexp=RE    a string must match the regular expression RE, which may
+//	             contain commas, so regexp must be the last rule of a tag
+//
+// Rules other than required do not apply to nil pointers, slices, or maps, so
+// an optional field can be declared as a pointer (e.g. *int) that does not
+// fail its min rule when it is omitted. They do apply to other zero values,
+// e.g. 0 fails min=1 and "" fails enum=a|b. Struct fields, pointers to
+// structs, and slices of structs are validated recursively.
+//
+// If any fields fail their rules, Validate returns FieldErrors that list all of
+// them, each with the path of the field, e.g. "items[2].name". Fields are named
+// like Bind names them, i.e. by their path, query, header, form, or json tags.
+// If a tag contains an invalid rule, a different error is returned.
+func Validate(value interface{}) error {
+	target := reflect.Indirect(reflect.ValueOf(value))
+	if target.Kind() != reflect.Struct {
+		return fmt.Errorf("bear: Validate requires a struct, not %T", value)
+	}
+	var errs FieldErrors
+	if err := validate(target, empty, &errs); err != nil {
+		return err
+	}
+	if 0 < len(errs) {
+		return errs
+	}
+	return nil
+}
+
+// Validated returns a handler that binds each request to a new T (see Bind)
+// and validates it (see Validate) before it stores it in the state of the
+// request Context with key and calls Next, so that the handlers that follow it
+// only run for valid requests, e.g.
+//
+//	mux.On("POST", "/users", bear.Validated(user), createUser)
+//
+// Binding errors are passed to (*Context).Error with the status 400 Bad
+// Request and validation failures with the status 422 Unprocessable Entity.
+func Validated[T any](key *Key[T]) HandlerFunc {
+	return func(_ http.ResponseWriter, _ *http.Request, ctx *Context) {
+		var value T
+		if err := ctx.Bind(&value); err != nil {
+			return
+		}
+		if err := Validate(&value); err != nil {
+			if _, ok := err.(FieldErrors); ok {
+				ctx.Error(http.StatusUnprocessableEntity, err)
+			} else {
+				ctx.Error(http.StatusInternalServerError, err)
+			}
+			return
+		}
+		key.Set(ctx, value).Next()
+	}
+}
+
+// validate checks the fields of the struct value, whose path is prefix.
+func validate(value reflect.Value, prefix string, errs *FieldErrors) error {
+	for index := 0; index < value.NumField(); index++ {
+		field, target := value.Type().Field(index), value.Field(index)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + fieldName(field)
+		if field.Anonymous {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+		if tag := field.Tag.Get("validate"); tag != empty {
+			if err := check(target, tag, name, errs); err != nil {
+				return fmt.Errorf("bear: %s.%s %s", value.Type(), field.Name, err)
+			}
+		}
+		if err := descend(target, name, errs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// descend validates value recursively if it is a struct, a pointer to a
+// struct, or a slice or an array of those.
+func descend(value reflect.Value, name string, errs *FieldErrors) error {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			return descend(value.Elem(), name, errs)
+		}
+	case reflect.Struct:
+		if name != empty {
+			name += "."
+		}
+		return validate(value, name, errs)
+	case reflect.Slice, reflect.Array:
+		for index := 0; index < value.Len(); index++ {
+			element := fmt.Sprintf("%s[%d]", name, index)
+			if err := descend(value.Index(index), element, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check applies the rules of tag to value, whose path is name.
+func check(value reflect.Value, tag string, name string, errs *FieldErrors) error {
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	for tag != empty {
+		rule := tag
+		if strings.HasPrefix(tag, "regexp=") {
+			tag = empty
+		} else if comma := strings.Index(tag, ","); comma >= 0 {
+			rule, tag = tag[:comma], tag[comma+1:]
+		} else {
+			tag = empty
+		}
+		rule, argument, _ := strings.Cut(rule, "=")
+		if rule == "required" {
+			if value.IsZero() || (value.Kind() == reflect.Slice ||
+				value.Kind() == reflect.Map) && 0 == value.Len() {
+				*errs = append(*errs, &FieldError{name, empty, "is required"})
+				return nil // the other rules of an empty field do not apply
+			}
+			continue
+		}
+		if absent(value) {
+			continue
+		}
+		message, err := apply(value, rule, argument)
+		if err != nil {
+			return err
+		} else if message != empty {
+			*errs = append(*errs, &FieldError{name, empty, message})
+		}
+	}
+	return nil
+}
+
+// absent returns true if value is a nil pointer, slice, or map, i.e. a field
+// that was omitted, as opposed to one that was set to its zero value.
+func absent(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		return value.IsNil()
+	}
+	return false
+}
+
+// apply returns a message that describes how value fails the rule (with its
+// argument), or an empty message if value satisfies it.
+func apply(value reflect.Value, rule string, argument string) (string, error) {
+	switch rule {
+	case "enum":
+		actual := fmt.Sprint(value.Interface())
+		for _, option := range strings.Split(argument, "|") {
+			if actual == option {
+				return empty, nil
+			}
+		}
+		return "must be one of " + strings.Join(strings.Split(argument, "|"), ", "),
+			nil
+	case "regexp":
+		if value.Kind() != reflect.String {
+			return empty, fmt.Errorf("rule %s requires a string", rule)
+		}
+		cached, ok := patterns.Load(argument)
+		if !ok {
+			compiled, err := regexp.Compile(argument)
+			if err != nil {
+				return empty, fmt.Errorf("rule %s: %s", rule, err)
+			}
+			cached, _ = patterns.LoadOrStore(argument, compiled)
+		}
+		if !cached.(*regexp.Regexp).MatchString(value.String()) {
+			return "must match " + argument, nil
+		}
+		return empty, nil
+	case "len", "max", "min":
+		limit, err := strconv.ParseFloat(argument, 64)
+		if err != nil {
+			return empty, fmt.Errorf("rule %s requires a number", rule)
+		}
+		actual, length := 0.0, false
+		switch value.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+			reflect.Int64:
+			actual = float64(value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+			reflect.Uint64:
+			actual = float64(value.Uint())
+		case reflect.Float32, reflect.Float64:
+			actual = value.Float()
+		case reflect.String:
+			actual, length = float64(len([]rune(value.String()))), true
+		case reflect.Slice, reflect.Map, reflect.Array:
+			actual, length = float64(value.Len()), true
+		default:
+			return empty, fmt.Errorf("rule %s does not apply to %s", rule, value.Type())
+		}
+		switch {
+		case rule == "len" && !length:
+			return empty, fmt.Errorf("rule %s does not apply to %s", rule, value.Type())
+		case rule == "len" && actual != limit:
+			return "must have a length of " + argument, nil
+		case rule == "min" && actual < limit && !length:
+			return "must be at least " + argument, nil
+		case rule == "min" && actual < limit:
+			return "must have a length of at least " + argument, nil
+		case rule == "max" && actual > limit && !length:
+			return "must be at most " + argument, nil
+		case rule == "max" && actual > limit:
+			return "must have a length of at most " + argument, nil
+		}
+		return empty, nil
+	default:
+		return empty, fmt.Errorf("has an unknown validation rule %q", rule)
+	}
+}
+
+// fieldName returns the name of a struct field as Bind names it.
+func fieldName(field reflect.StructField) string {
+	for _, source := range []string{"path", "query", "header", "form"} {
+		if name, ok := field.Tag.Lookup(source); ok {
+			return name
+		}
+	}
+	return label(field)
+}
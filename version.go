@@ -0,0 +1,153 @@
+// Copyright 2015 Afshin Darian. All rights reserved.
+// Use of this source code is governed by The MIT License
+// that can be found in the LICENSE file.
+
+package bear
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type version struct {
+	deprecation time.Time
+	deprecated  bool
+	mux         *Mux
+	number      int
+	sunset      time.Time
+}
+
+// Versioning determines how the API version of a request is read. Any
+// combination of its fields may be set; if a request specifies its version in
+// more than one way, the path prefix wins over the header, which wins over the
+// Accept header.
+type Versioning struct {
+	// Header is the name of a request header whose value is the version
+	// number, with or without a leading "v", e.g. "API-Version: 2".
+	Header string
+	// Prefix reads the version from a leading /v{n} path segment, which is
+	// removed from the path before it is matched against the versioned routes,
+	// e.g. a request to /v2/users matches the pattern "/users" of version 2.
+	Prefix bool
+	// Vendor reads the version from a vendor media type in the Accept header,
+	// e.g. if Vendor is "acme": "Accept: application/vnd.acme.v2+json".
+	Vendor string
+	vendor *regexp.Regexp
+}
+
+// parse returns the version a request asks for (or 0 if it does not specify
+// one) and its path with any version prefix removed.
+func (scheme *Versioning) parse(req *http.Request, path string) (int, string) {
+	if scheme.Prefix {
+		if match := ver.FindStringSubmatch(path); 0 < len(match) {
+			number, _ := strconv.Atoi(match[1])
+			return number, slash + path[len(match[0]):]
+		}
+	}
+	if scheme.Header != empty {
+		value := strings.TrimPrefix(
+			strings.ToLower(strings.TrimSpace(req.Header.Get(scheme.Header))), "v")
+		if number, err := strconv.Atoi(value); err == nil {
+			return number, path
+		}
+	}
+	if nil != scheme.vendor {
+		accept := req.Header.Get("Accept")
+		if match := scheme.vendor.FindStringSubmatch(accept); 0 < len(match) {
+			number, _ := strconv.Atoi(match[1])
+			return number, path
+		}
+	}
+	return 0, path
+}
+
+// Deprecate marks an API version as deprecated. Responses served by that
+// version include a Deprecation header (RFC 9745) with the deprecation date,
+// or "true" if deprecation is the zero time, and a Sunset header (RFC 8594) if
+// sunset is not the zero time. It returns an error if the version does not
+// exist.
+func (mux *Mux) Deprecate(number int, deprecation time.Time, sunset time.Time) error {
+	for _, v := range mux.versions {
+		if v.number == number {
+			v.deprecated, v.deprecation, v.sunset = true, deprecation, sunset
+			return nil
+		}
+	}
+	return fmt.Errorf("bear: version %d does not exist", number)
+}
+
+// Version returns a sub-router for the routes of an API version. A request
+// that specifies a version (see VersionBy) is served by the newest version that
+// is not newer than the one requested and that has a route matching the
+// request; a request that does not specify a version is served by the newest
+// version with a matching route. If no version has a matching route, the
+// request is matched against the routes of mux itself.
+//
+// Version sub-routers only contribute routes (and Use handlers): the Always,
+// After, and NotFound handlers of mux apply to every versioned request, and so
+// do the Use handlers of mux, whose prefixes are matched against the path
+// without its version prefix and which run before those of the version.
+// Calling Version more than once with the same number returns the same
+// sub-router.
+func (mux *Mux) Version(number int) *Mux {
+	index := 0
+	for ; index < len(mux.versions); index++ {
+		if v := mux.versions[index]; v.number == number {
+			return v.mux
+		} else if v.number < number {
+			break
+		}
+	}
+	v := &version{mux: New(), number: number}
+	mux.versions = append(mux.versions, nil)
+	copy(mux.versions[index+1:], mux.versions[index:])
+	mux.versions[index] = v
+	return v.mux
+}
+
+// VersionBy sets how the API version of a request is determined.
+func (mux *Mux) VersionBy(scheme Versioning) {
+	scheme.vendor = nil
+	if scheme.Vendor != empty {
+		scheme.vendor = regexp.MustCompile(`(?i)\bapplication/vnd\.` +
+			regexp.QuoteMeta(scheme.Vendor) + `\.v(\d+)\b`)
+	}
+	mux.scheme = scheme
+}
+
+// versioned serves a request with the routes of the newest suitable version of
+// routes (i.e. mux or one of its host sub-routers). It returns false if no
+// version has a route that matches the request.
+func (mux *Mux) versioned(routes *Mux, res http.ResponseWriter,
+	req *http.Request, params map[string]string,
+	state map[interface{}]interface{}, path string) bool {
+	requested, path := routes.scheme.parse(req, path)
+	for _, v := range routes.versions {
+		if 0 < requested && requested < v.number {
+			continue
+		}
+		tr, wildcards := v.mux.tree(req.Method)
+		context := mux.context(routes, res, req, params, state)
+		if node := v.mux.match(context, tr, *wildcards, path); nil != node {
+			if v.deprecated {
+				if v.deprecation.IsZero() {
+					res.Header().Set("Deprecation", "true")
+				} else {
+					res.Header().Set("Deprecation",
+						fmt.Sprintf("@%d", v.deprecation.Unix()))
+				}
+				if !v.sunset.IsZero() {
+					res.Header().Set("Sunset",
+						v.sunset.UTC().Format(http.TimeFormat))
+				}
+			}
+			mux.dispatch(context, node)
+			return true
+		}
+	}
+	return false
+}